@@ -2,10 +2,14 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
 )
@@ -18,10 +22,41 @@ const (
 func main() {
 	flag.Usage = usage
 
-	width := flag.Int("w", 1024, "Window width")
-	height := flag.Int("h", 768, "Window height")
+	opts := defaultOptions()
+
+	flag.IntVar(&opts.Width, "w", opts.Width, "Window width")
+	flag.IntVar(&opts.Height, "h", opts.Height, "Window height")
+	config := flag.String("c", configPath(), "Config file")
+	mode := flag.String("mode", "fit", "View mode, fit, fit-width, fit-height, actual or shrink")
+	filter := flag.String("filter", "auto", "Resampling filter, auto, nearest, bilinear, bicubic, mitchell, lanczos2 or lanczos3")
+	flag.BoolVar(&opts.Preview, "preview", opts.Preview, "Draw nearest neighbour preview before scaling with filter")
+	flag.BoolVar(&opts.Exif, "exif", opts.Exif, "Apply EXIF orientation")
+	flag.BoolVar(&opts.Animate, "animate", opts.Animate, "Play animations")
+	flag.Float64Var(&opts.Exposure, "exposure", opts.Exposure, "Exposure of HDR images in stops")
+	tonemap := flag.String("tonemap", "reinhard", "Tone mapping of HDR images, reinhard, aces or clip")
+	flag.BoolVar(&opts.Dither, "dither", opts.Dither, "Dither images with more than 8 bits per channel")
+	flag.BoolVar(&opts.ICC, "icc", opts.ICC, "Convert images with embedded ICC profile to sRGB, or to display profile")
+	profile := flag.String("profile", "", "ICC profile of display, on X11 default is profile of screen")
+	cacheSize := flag.String("cache", "256M", "Memory budget for cache of decoded and scaled images, 0 disables cache")
+	flag.IntVar(&opts.Prefetch, "prefetch", opts.Prefetch, "Number of next and previous images decoded in background")
+	flag.DurationVar(&opts.Slideshow, "slideshow", opts.Slideshow, "Start slideshow with interval, i.e. 5s")
+	flag.BoolVar(&opts.Loop, "loop", opts.Loop, "Loop slideshow")
+	flag.BoolVar(&opts.Shuffle, "shuffle", opts.Shuffle, "Show slideshow in random order")
 	version := flag.Bool("v", false, "Print version and exit")
-	filelist := flag.String("f", "", "Use list of images from file, one per line")
+	filelist := flag.String("f", "", "Use list of images from file, one per line, M3U playlist or JSON array")
+	nul := flag.Bool("0", false, "Names in list from file or stdin are separated by NUL, i.e. find -print0")
+	scan := &Scan{}
+	flag.BoolVar(&scan.Recursive, "r", false, "Scan directories recursively")
+	flag.Var(&scan.Include, "include", "Use only files in directories matching glob, i.e. *.jpg, can be repeated")
+	flag.Var(&scan.Exclude, "exclude", "Skip files in directories matching glob, can be repeated")
+	flag.BoolVar(&scan.Magic, "magic", false, "Detect images in directories by magic bytes instead of extension")
+	flag.StringVar(&scan.Sort, "sort", "name", "Sort images in directories by name, natural, mtime, size or random")
+	crawlPages := flag.Bool("crawl", false, "Browse images on HTML pages and directory listings given as URLs")
+	crawlDepth := flag.Int("crawl-depth", 0, "Follow links to other pages up to depth (crawl)")
+	sameHost := flag.Bool("same-host", false, "Use only images and pages on host of the page (crawl)")
+	headless := flag.Bool("headless", false, "Render offscreen, without display")
+	keys := flag.String("keys", "", "Read key names to press from file, - for stdin (headless)")
+	output := flag.String("o", "", "Write final frame as PNG, or every frame if path has format verb, i.e. %03d (headless)")
 
 	flag.Parse()
 
@@ -30,7 +65,58 @@ func main() {
 		os.Exit(0)
 	}
 
-	args := arguments(flag.Args())
+	err := loadConfig(*config, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(1)
+	}
+
+	opts.HTTP.loadEnv()
+
+	m, ok := viewModes[*mode]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown view mode %s\n", *mode)
+		os.Exit(1)
+	}
+
+	opts.Mode = m
+
+	opts.Filter, err = parseFilter(*filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(1)
+	}
+
+	opts.ToneMap, err = parseToneMap(*tonemap)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(1)
+	}
+
+	if *profile != "" {
+		data, err := ioutil.ReadFile(*profile)
+		if err == nil {
+			opts.Profile, err = parseDisplayProfile(data)
+		}
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", *profile, err.Error())
+			os.Exit(1)
+		}
+	}
+
+	opts.Cache, err = parseSize(*cacheSize)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(1)
+	}
+
+	if !validSort(scan.Sort) {
+		fmt.Fprintf(os.Stderr, "unknown sort order %s\n", scan.Sort)
+		os.Exit(1)
+	}
+
+	args := arguments(flag.Args(), scan)
 
 	if *filelist != "" {
 		file, err := os.Open(*filelist)
@@ -39,15 +125,61 @@ func main() {
 			os.Exit(1)
 		}
 
-		ln := lines(file)
-		args = append(args, ln...)
+		entries, err := readList(file, *filelist, *nul)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", *filelist, err.Error())
+			os.Exit(1)
+		}
+
+		args = opts.addEntries(args, entries)
 
 		file.Close()
 	}
 
-	if piped() {
-		ln := lines(os.Stdin)
-		args = append(args, ln...)
+	if piped() && *keys != "-" && !stdinArg(args) {
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			os.Exit(1)
+		}
+
+		if stdinImage(data) {
+			stdin.data = data
+			args = append(args, "-")
+		} else {
+			entries, _ := readList(bytes.NewReader(data), "", *nul)
+			args = opts.addEntries(args, entries)
+		}
+	}
+
+	opts.HTTP.authorize(args)
+
+	if *crawlPages {
+		// interrupt stops crawling, images found until then are shown
+		ctx, cancel := context.WithCancel(context.Background())
+
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+
+		go func() {
+			select {
+			case <-interrupt:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		args = crawl(ctx, args, opts.HTTP, *crawlDepth, *sameHost)
+
+		signal.Stop(interrupt)
+		cancel()
+	}
+
+	// single file is opened with other images in its directory, like in desktop viewers
+	if len(args) == 1 && len(flag.Args()) == 1 && !*headless {
+		if fi, err := os.Stat(args[0]); err == nil && fi.Mode().IsRegular() {
+			args, opts.Start = scan.siblings(args[0])
+		}
 	}
 
 	if len(args) == 0 {
@@ -55,21 +187,102 @@ func main() {
 		os.Exit(1)
 	}
 
-	display(args, *width, *height)
+	if *headless {
+		var script io.Reader
+		if *keys == "-" {
+			script = os.Stdin
+		} else if *keys != "" {
+			file, err := os.Open(*keys)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+				os.Exit(1)
+			}
+
+			defer file.Close()
+			script = file
+		}
+
+		err := displayHeadless(args, opts, script, *output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	display(args, opts)
 }
 
 // usage prints default usage.
 func usage() {
 	fmt.Fprintf(os.Stderr, "Usage: %s [FILE1 [FILE2 [...]]]\n", appName)
+	fmt.Fprintf(os.Stderr, "Files can be also URLs, file:// URLs, data: URIs, or - for image on stdin\n")
 	fmt.Fprintf(os.Stderr, `
+  -c path
+	Config file (default $XDG_CONFIG_HOME/goiv/config)
   -f path
-	Use list of images from file, one per line
+	Use list of images from file, one per line, M3U playlist or JSON array
+  -0
+	Names in list from file or stdin are separated by NUL, i.e. find -print0
+  -r
+	Scan directories recursively
+  -include glob
+	Use only files in directories matching glob, i.e. *.jpg, can be repeated
+  -exclude glob
+	Skip files in directories matching glob, can be repeated
+  -magic
+	Detect images in directories by magic bytes instead of extension
+  -sort string
+	Sort images in directories by name, natural, mtime, size or random (default name)
+  -crawl
+	Browse images on HTML pages and directory listings given as URLs
+  -crawl-depth int
+	Follow links to other pages up to depth (crawl)
+  -same-host
+	Use only images and pages on host of the page (crawl)
+  -mode string
+	View mode, fit, fit-width, fit-height, actual or shrink (default fit)
+  -filter string
+	Resampling filter, auto, nearest, bilinear, bicubic, mitchell, lanczos2 or lanczos3 (default auto)
+  -preview
+	Draw nearest neighbour preview before scaling with filter (default true)
+  -exif
+	Apply EXIF orientation (default true)
+  -animate
+	Play animations, when false they start paused (default true)
+  -exposure float
+	Exposure of HDR images in stops
+  -tonemap string
+	Tone mapping of HDR images, reinhard, aces or clip (default reinhard)
+  -dither
+	Dither images with more than 8 bits per channel
+  -icc
+	Convert images with embedded ICC profile to sRGB, or to display profile (default true)
+  -profile file
+	ICC profile of display, on X11 default is profile of screen (_ICC_PROFILE)
+  -cache size
+	Memory budget for cache of decoded and scaled images, 0 disables cache (default 256M)
+  -prefetch int
+	Number of next and previous images decoded in background (default 2)
+  -slideshow duration
+	Start slideshow with interval, i.e. 5s
+  -loop
+	Loop slideshow
+  -shuffle
+	Show slideshow in random order
   -w int
 	Window width (default 1024)
   -h int
 	Window height (default 768)
   -v
 	Print version and exit
+  -headless
+	Render offscreen, without display
+  -keys path
+	Read key names to press from file, - for stdin (headless)
+  -o path
+	Write final frame as PNG, or every frame if path has format verb, i.e. %%03d (headless)
 
 Keybindings:
 
@@ -88,6 +301,57 @@ Keybindings:
   , / .
 	Go to first/last image
 
+  + / - / Wheel
+	Zoom in/out
+
+  z / w / e / 1 / s
+	Fit to window/width/height, actual size, shrink only
+
+  H / J / K / L / Drag
+	Pan image
+
+  i
+	Cycle resampling filter
+
+  r / R
+	Rotate 90 degrees clockwise/counter-clockwise
+
+  m / v
+	Flip horizontally/vertically
+
+  p / F5
+	Start/stop slideshow
+
+  } / {
+	Faster/slower slideshow
+
+  a
+	Pause/resume animation
+
+  n / b
+	Next/previous animation frame
+
+  > / <
+	Faster/slower animation
+
+  ctrl+PageDown / ctrl+PageUp / ctrl+j / ctrl+k
+	Next/previous page of multi-page TIFF or size of ICO
+
+  ctrl+n / ctrl+p
+	Select next/previous PSD layer
+
+  t
+	Show/hide selected PSD layer
+
+  x / X / 0
+	Increase/decrease/reset exposure of HDR image
+
+  T
+	Cycle tone mapping of HDR image
+
+  c
+	Turn color management on/off
+
   q / Escape
 	Quit
 
@@ -96,11 +360,31 @@ Keybindings:
 	fmt.Fprintf(os.Stderr, "\n")
 }
 
-// arguments returns slice of arguments.
-func arguments(in []string) []string {
+// fileExists checks if file exists.
+func fileExists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
+
+// arguments returns slice of arguments, directories are replaced with images in them.
+func arguments(in []string, scan *Scan) []string {
 	out := make([]string, 0)
 	for _, arg := range in {
-		if _, err := os.Stat(arg); err == nil {
+		if p, ok := filePath(arg); ok {
+			arg = p
+		}
+
+		if arg == "-" {
+			out = append(out, arg)
+		} else if fi, err := os.Stat(arg); err == nil && fi.IsDir() {
+			images, err := scan.directory(arg)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			}
+			out = append(out, images...)
+		} else if err == nil {
+			out = append(out, arg)
+		} else if f, page := splitPage(arg); page > 1 && fileExists(f) {
 			out = append(out, arg)
 		} else {
 			if isURL(arg) {
@@ -143,10 +427,37 @@ func piped() bool {
 	}
 }
 
-// isURL checks if arguments is URL.
+// stdinArg checks if image is read from stdin.
+func stdinArg(args []string) bool {
+	for _, arg := range args {
+		if arg == "-" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// stdinImage checks if piped data is image and not list of images, first line of list is file or URL.
+func stdinImage(data []byte) bool {
+	if t := sniff("", data); t == "" || !validHeader(t, data) {
+		return false
+	}
+
+	line := data
+	if i := bytes.IndexAny(data, "\r\n\x00"); i >= 0 {
+		line = data[:i]
+	}
+
+	return !fileExists(string(line)) && !isURL(string(line))
+}
+
+// isURL checks if arguments is URL, file URL or data URI.
 func isURL(arg string) bool {
-	if strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://") {
-		return true
+	for _, prefix := range []string{"http://", "https://", "file://", "data:"} {
+		if strings.HasPrefix(arg, prefix) {
+			return true
+		}
 	}
 
 	return false
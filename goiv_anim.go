@@ -0,0 +1,527 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"time"
+
+	"golang.org/x/image/webp"
+)
+
+// Animation is sequence of composed frames, where single image is expected it is its first frame.
+type Animation struct {
+	image.Image
+
+	Frames []image.Image
+	Delays []time.Duration
+
+	// Loops is number of times animation is played, 0 is forever.
+	Loops int
+
+	// Truncated is set when frames after memory limit were dropped.
+	Truncated bool
+
+	size int
+}
+
+// animationMemory is maximal size of composed frames in bytes, frames after it are dropped.
+const animationMemory = 512 << 20
+
+// add appends frame with delay, very short delays are slowed down like in web browsers.
+// It reports false when frame does not fit in memory limit, decoding should stop then.
+func (a *Animation) add(frame *image.RGBA, delay time.Duration) bool {
+	if len(a.Frames) > 0 && a.size+len(frame.Pix) > animationMemory {
+		a.Truncated = true
+		return false
+	}
+
+	if delay < 20*time.Millisecond {
+		delay = 100 * time.Millisecond
+	}
+
+	if a.Image == nil {
+		a.Image = frame
+	}
+
+	a.Frames = append(a.Frames, frame)
+	a.Delays = append(a.Delays, delay)
+	a.size += len(frame.Pix)
+
+	return true
+}
+
+// pngSignature is signature of PNG file.
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// decodeAnimation decodes animated GIF, PNG or WebP, it returns nil if data is not animation.
+func decodeAnimation(ctx context.Context, data []byte) (*Animation, error) {
+	var a *Animation
+	var err error
+
+	switch {
+	case bytes.HasPrefix(data, []byte("GIF8")):
+		a, err = decodeGIF(ctx, data)
+	case bytes.HasPrefix(data, pngSignature):
+		a, err = decodeAPNG(ctx, data)
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		a, err = decodeWebP(ctx, data)
+	}
+
+	if err != nil || a == nil || len(a.Frames) < 2 {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// decodeGIF decodes and composes all frames of GIF, GIF with one frame is not decoded.
+func decodeGIF(ctx context.Context, data []byte) (*Animation, error) {
+	if !gifAnimated(data) {
+		return nil, nil
+	}
+
+	g, err := gif.DecodeAll(newReader(ctx, bytes.NewReader(data), int64(len(data)), nil))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(g.Image) < 2 {
+		return nil, nil
+	}
+
+	a := &Animation{}
+
+	switch {
+	case g.LoopCount < 0:
+		a.Loops = 1
+	case g.LoopCount > 0:
+		a.Loops = g.LoopCount + 1
+	}
+
+	r := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if r.Empty() {
+		for _, p := range g.Image {
+			r = r.Union(p.Bounds())
+		}
+	}
+
+	canvas := image.NewRGBA(r)
+
+	for i, p := range g.Image {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+
+		var prev *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			prev = snapshot(canvas)
+		}
+
+		draw.Draw(canvas, p.Bounds(), p, p.Bounds().Min, draw.Over)
+		if !a.add(snapshot(canvas), time.Duration(g.Delay[i])*10*time.Millisecond) {
+			break
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, p.Bounds(), image.Transparent, image.ZP, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = prev
+		}
+	}
+
+	return a, nil
+}
+
+// gifAnimated checks if GIF has more than one frame, blocks are skipped without decoding.
+func gifAnimated(data []byte) bool {
+	if len(data) < 13 {
+		return false
+	}
+
+	// global color table follows screen descriptor
+	i := 13
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&7 + 1)
+	}
+
+	frames := 0
+	for i < len(data) {
+		switch data[i] {
+		case 0x21: // extension, label and sub-blocks
+			i = gifBlocks(data, i+2)
+		case 0x2c: // image descriptor, local color table, LZW code size and sub-blocks
+			frames++
+			if frames > 1 {
+				return true
+			}
+
+			if i+10 > len(data) {
+				return false
+			}
+
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&7 + 1)
+			}
+
+			i = gifBlocks(data, i+1)
+		default:
+			return false
+		}
+	}
+
+	return false
+}
+
+// gifBlocks returns offset after sub-blocks at offset i, sub-blocks end with empty block.
+func gifBlocks(data []byte, i int) int {
+	for i < len(data) && data[i] != 0 {
+		i += int(data[i]) + 1
+	}
+
+	return i + 1
+}
+
+// apngFrame is frame control chunk and image data of APNG frame.
+type apngFrame struct {
+	fctl []byte
+	data [][]byte
+}
+
+// decodeAPNG decodes and composes all frames of animated PNG.
+// Every frame is decoded as standalone PNG built from frame data and chunks shared by all frames.
+func decodeAPNG(ctx context.Context, data []byte) (*Animation, error) {
+	var ihdr []byte
+	var shared [][]byte
+	var frames []*apngFrame
+	var cur *apngFrame
+
+	animated := false
+	plays := 0
+	idat := false
+
+chunks:
+	for i := len(pngSignature); i+12 <= len(data); {
+		n := int(binary.BigEndian.Uint32(data[i:]))
+		if n < 0 || i+12+n > len(data) {
+			return nil, fmt.Errorf("png: invalid chunk length")
+		}
+
+		typ := string(data[i+4 : i+8])
+		body := data[i+8 : i+8+n]
+
+		switch typ {
+		case "IHDR":
+			ihdr = body
+		case "acTL":
+			if len(body) < 8 {
+				return nil, fmt.Errorf("png: invalid acTL chunk")
+			}
+			animated = true
+			plays = int(binary.BigEndian.Uint32(body[4:]))
+		case "fcTL":
+			if len(body) < 26 {
+				return nil, fmt.Errorf("png: invalid fcTL chunk")
+			}
+			cur = &apngFrame{fctl: body}
+			frames = append(frames, cur)
+		case "IDAT":
+			// default image is first frame only when its fcTL precedes it
+			idat = true
+			if cur != nil {
+				cur.data = append(cur.data, body)
+			}
+		case "fdAT":
+			if cur != nil && len(body) > 4 {
+				cur.data = append(cur.data, body[4:])
+			}
+		case "IEND":
+			break chunks
+		default:
+			if !idat {
+				shared = append(shared, data[i:i+12+n])
+			}
+		}
+
+		i += 12 + n
+	}
+
+	if !animated || len(frames) < 2 || len(ihdr) < 13 {
+		return nil, nil
+	}
+
+	a := &Animation{}
+	a.Loops = plays
+
+	width, height := int(binary.BigEndian.Uint32(ihdr)), int(binary.BigEndian.Uint32(ihdr[4:]))
+	if !validSize(width, height, 4) {
+		return nil, fmt.Errorf("png: invalid size %dx%d", width, height)
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for i, f := range frames {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if len(f.data) == 0 {
+			continue
+		}
+
+		be := binary.BigEndian
+		w, h := be.Uint32(f.fctl[4:]), be.Uint32(f.fctl[8:])
+		x, y := int(be.Uint32(f.fctl[12:])), int(be.Uint32(f.fctl[16:]))
+		num, den := be.Uint16(f.fctl[20:]), be.Uint16(f.fctl[22:])
+		dispose, blend := f.fctl[24], f.fctl[25]
+
+		// frame is checked before its PNG is decoded
+		r := image.Rect(x, y, x+int(w), y+int(h))
+		if !validSize(int(w), int(h), 4) || x < 0 || y < 0 || !r.In(canvas.Rect) {
+			return nil, fmt.Errorf("png: invalid frame %dx%d at %d,%d", w, h, x, y)
+		}
+
+		img, err := png.Decode(bytes.NewReader(apngStream(ihdr, w, h, shared, f.data)))
+		if err != nil {
+			return nil, err
+		}
+
+		if i == 0 && dispose == 2 {
+			dispose = 1
+		}
+
+		var prev *image.RGBA
+		if dispose == 2 {
+			prev = snapshot(canvas)
+		}
+
+		op := draw.Over
+		if blend == 0 {
+			op = draw.Src
+		}
+
+		draw.Draw(canvas, r, img, img.Bounds().Min, op)
+
+		if den == 0 {
+			den = 100
+		}
+
+		if !a.add(snapshot(canvas), time.Duration(num)*time.Second/time.Duration(den)) {
+			break
+		}
+
+		switch dispose {
+		case 1:
+			draw.Draw(canvas, r, image.Transparent, image.ZP, draw.Src)
+		case 2:
+			canvas = prev
+		}
+	}
+
+	return a, nil
+}
+
+// apngStream returns PNG with header of given size, shared chunks and image data.
+func apngStream(ihdr []byte, width, height uint32, shared [][]byte, data [][]byte) []byte {
+	var b bytes.Buffer
+	b.Write(pngSignature)
+
+	hdr := append([]byte{}, ihdr...)
+	binary.BigEndian.PutUint32(hdr, width)
+	binary.BigEndian.PutUint32(hdr[4:], height)
+	pngChunk(&b, "IHDR", hdr)
+
+	for _, c := range shared {
+		b.Write(c)
+	}
+
+	for _, d := range data {
+		pngChunk(&b, "IDAT", d)
+	}
+
+	pngChunk(&b, "IEND", nil)
+
+	return b.Bytes()
+}
+
+// pngChunk writes PNG chunk.
+func pngChunk(b *bytes.Buffer, typ string, data []byte) {
+	var n [4]byte
+
+	binary.BigEndian.PutUint32(n[:], uint32(len(data)))
+	b.Write(n[:])
+	b.WriteString(typ)
+	b.Write(data)
+
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(typ))
+	crc.Write(data)
+
+	binary.BigEndian.PutUint32(n[:], crc.Sum32())
+	b.Write(n[:])
+}
+
+// decodeWebP decodes and composes all frames of animated WebP.
+// Every frame is decoded as standalone WebP built from frame data.
+func decodeWebP(ctx context.Context, data []byte) (*Animation, error) {
+	chunks := riffChunks(data[12:])
+	if len(chunks) == 0 || string(chunks[0][:4]) != "VP8X" || len(chunks[0]) < 18 || chunks[0][8]&0x02 == 0 {
+		return nil, nil
+	}
+
+	vp8x := chunks[0][8:]
+
+	width, height := le24(vp8x[4:])+1, le24(vp8x[7:])+1
+	if !validSize(width, height, 4) {
+		return nil, fmt.Errorf("webp: invalid size %dx%d", width, height)
+	}
+
+	a := &Animation{}
+	canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for _, c := range chunks[1:] {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		body := c[8:]
+
+		switch string(c[:4]) {
+		case "ANIM":
+			if len(body) >= 6 {
+				a.Loops = int(binary.LittleEndian.Uint16(body[4:]))
+			}
+		case "ANMF":
+			if len(body) < 16 {
+				return nil, fmt.Errorf("webp: invalid ANMF chunk")
+			}
+
+			x, y := le24(body)*2, le24(body[3:])*2
+			w, h := le24(body[6:])+1, le24(body[9:])+1
+			duration := le24(body[12:])
+			flags := body[15]
+
+			// frame is checked before it is decoded
+			r := image.Rect(x, y, x+w, y+h)
+			if !validSize(w, h, 4) || !r.In(canvas.Rect) {
+				return nil, fmt.Errorf("webp: invalid frame %dx%d at %d,%d", w, h, x, y)
+			}
+
+			img, err := webp.Decode(bytes.NewReader(webpStream(body[16:], w, h)))
+			if err != nil {
+				return nil, err
+			}
+
+			op := draw.Over
+			if flags&0x02 != 0 {
+				op = draw.Src
+			}
+
+			draw.Draw(canvas, r, img, img.Bounds().Min, op)
+
+			if !a.add(snapshot(canvas), time.Duration(duration)*time.Millisecond) {
+				return a, nil
+			}
+
+			if flags&0x01 != 0 {
+				draw.Draw(canvas, r, image.Transparent, image.ZP, draw.Src)
+			}
+		}
+	}
+
+	return a, nil
+}
+
+// webpStream returns WebP with frame data, VP8X header is added for alpha.
+func webpStream(frame []byte, width, height int) []byte {
+	var b bytes.Buffer
+
+	chunks := riffChunks(frame)
+	for _, c := range chunks {
+		if string(c[:4]) == "ALPH" {
+			vp8x := make([]byte, 10)
+			vp8x[0] = 0x10
+			putLE24(vp8x[4:], width-1)
+			putLE24(vp8x[7:], height-1)
+			riffChunk(&b, "VP8X", vp8x)
+			break
+		}
+	}
+
+	for _, c := range chunks {
+		b.Write(c)
+	}
+
+	var n [4]byte
+	binary.LittleEndian.PutUint32(n[:], uint32(b.Len()+4))
+
+	return append([]byte("RIFF"+string(n[:])+"WEBP"), b.Bytes()...)
+}
+
+// riffChunks returns RIFF chunks with headers and padding.
+func riffChunks(data []byte) [][]byte {
+	chunks := make([][]byte, 0)
+
+	for i := 0; i+8 <= len(data); {
+		n := int(binary.LittleEndian.Uint32(data[i+4:]))
+		if n < 0 || i+8+n > len(data) {
+			break
+		}
+
+		end := i + 8 + n + n&1
+		if end > len(data) {
+			end = len(data)
+		}
+
+		chunks = append(chunks, data[i:end])
+		i = end
+	}
+
+	return chunks
+}
+
+// riffChunk writes RIFF chunk.
+func riffChunk(b *bytes.Buffer, typ string, data []byte) {
+	var n [4]byte
+	binary.LittleEndian.PutUint32(n[:], uint32(len(data)))
+
+	b.WriteString(typ)
+	b.Write(n[:])
+	b.Write(data)
+
+	if len(data)%2 == 1 {
+		b.WriteByte(0)
+	}
+}
+
+// le24 returns 24-bit little endian integer.
+func le24(b []byte) int {
+	return int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+}
+
+// putLE24 puts 24-bit little endian integer.
+func putLE24(b []byte, v int) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
+
+// snapshot returns copy of image.
+func snapshot(img *image.RGBA) *image.RGBA {
+	s := image.NewRGBA(img.Rect)
+	copy(s.Pix, img.Pix)
+
+	return s
+}
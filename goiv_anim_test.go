@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// apng returns APNG with canvas size and two frames of size at offset, frame data is not valid.
+func apng(width, height, w, h, x, y uint32) []byte {
+	be := binary.BigEndian
+
+	var b bytes.Buffer
+	b.Write(pngSignature)
+
+	ihdr := make([]byte, 13)
+	be.PutUint32(ihdr, width)
+	be.PutUint32(ihdr[4:], height)
+	ihdr[8], ihdr[9] = 8, 6
+	pngChunk(&b, "IHDR", ihdr)
+
+	pngChunk(&b, "acTL", make([]byte, 8))
+
+	for i := 0; i < 2; i++ {
+		fctl := make([]byte, 26)
+		be.PutUint32(fctl[4:], w)
+		be.PutUint32(fctl[8:], h)
+		be.PutUint32(fctl[12:], x)
+		be.PutUint32(fctl[16:], y)
+		pngChunk(&b, "fcTL", fctl)
+		pngChunk(&b, "fdAT", make([]byte, 8))
+	}
+
+	pngChunk(&b, "IEND", nil)
+
+	return b.Bytes()
+}
+
+// webpAnim returns animated WebP with canvas size and frame of size at offset, frame data is not valid.
+func webpAnim(width, height, w, h, x, y int) []byte {
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x02
+	putLE24(vp8x[4:], width-1)
+	putLE24(vp8x[7:], height-1)
+
+	anmf := make([]byte, 16+8)
+	putLE24(anmf, x/2)
+	putLE24(anmf[3:], y/2)
+	putLE24(anmf[6:], w-1)
+	putLE24(anmf[9:], h-1)
+
+	var chunks bytes.Buffer
+	riffChunk(&chunks, "VP8X", vp8x)
+	riffChunk(&chunks, "ANIM", make([]byte, 6))
+	riffChunk(&chunks, "ANMF", anmf)
+
+	var b bytes.Buffer
+	riffChunk(&b, "RIFF", append([]byte("WEBP"), chunks.Bytes()...))
+
+	return b.Bytes()
+}
+
+func TestDecodeAnimationSize(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"apng canvas", apng(1<<30, 1<<30, 1, 1, 0, 0)},
+		{"apng frame", apng(4, 4, 1<<30, 1<<30, 0, 0)},
+		{"apng frame outside", apng(4, 4, 4, 4, 2, 0)},
+		{"webp canvas", webpAnim(1<<24, 1<<24, 1, 1, 0, 0)},
+		{"webp frame outside", webpAnim(4, 4, 4, 4, 2, 2)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := decodeAnimation(context.Background(), tt.data)
+			if err == nil || !strings.Contains(err.Error(), "invalid") {
+				t.Errorf("got %v, %v, want invalid size", a, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"container/list"
+	"image"
+	"strings"
+	"sync"
+)
+
+// cache is LRU cache of decoded and scaled images bounded by memory budget.
+// Concurrent loads of the same key are done only once.
+type cache struct {
+	mu      sync.Mutex
+	budget  int64
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+	pending map[string]*call
+}
+
+// entry is cached image.
+type entry struct {
+	key  string
+	img  image.Image
+	size int64
+}
+
+// call is in-flight load.
+type call struct {
+	done chan struct{}
+	img  image.Image
+	err  error
+}
+
+// newCache returns new cache with budget in bytes.
+func newCache(budget int64) *cache {
+	c := &cache{}
+	c.budget = budget
+	c.lru = list.New()
+	c.entries = make(map[string]*list.Element)
+	c.pending = make(map[string]*call)
+
+	return c
+}
+
+// Get returns cached image, or image returned by load, which is then cached.
+func (c *cache) Get(key string, load func() (image.Image, error)) (image.Image, error) {
+	c.mu.Lock()
+
+	if e, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(e)
+		c.mu.Unlock()
+		return e.Value.(*entry).img, nil
+	}
+
+	if p, ok := c.pending[key]; ok {
+		c.mu.Unlock()
+		<-p.done
+		return p.img, p.err
+	}
+
+	p := &call{done: make(chan struct{})}
+	c.pending[key] = p
+	c.mu.Unlock()
+
+	p.img, p.err = load()
+
+	c.mu.Lock()
+	delete(c.pending, key)
+	if p.err == nil {
+		c.add(key, p.img)
+	}
+	c.mu.Unlock()
+
+	close(p.done)
+
+	return p.img, p.err
+}
+
+// Lookup returns cached image.
+func (c *cache) Lookup(key string) (image.Image, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(e)
+		return e.Value.(*entry).img, true
+	}
+
+	return nil, false
+}
+
+// Put adds image to cache.
+func (c *cache) Put(key string, img image.Image) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.add(key, img)
+}
+
+// Purge removes all entries with key prefix.
+func (c *cache) Purge(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, e := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(e)
+		}
+	}
+}
+
+// add adds image and evicts least recently used entries over budget, must be called with lock held.
+func (c *cache) add(key string, img image.Image) {
+	size := imageSize(img)
+	if size > c.budget {
+		return
+	}
+
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+
+	c.entries[key] = c.lru.PushFront(&entry{key, img, size})
+	c.size += size
+
+	for c.size > c.budget {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove removes entry, must be called with lock held.
+func (c *cache) remove(e *list.Element) {
+	en := e.Value.(*entry)
+
+	c.lru.Remove(e)
+	delete(c.entries, en.key)
+	c.size -= en.size
+}
+
+// imageSize returns approximate memory size of image in bytes.
+func imageSize(img image.Image) int64 {
+	if a, ok := img.(*Animation); ok {
+		size := int64(0)
+		for _, f := range a.Frames {
+			size += imageSize(f)
+		}
+		return size
+	}
+
+	if t, ok := img.(*Tagged); ok {
+		return imageSize(t.Image)
+	}
+
+	b := img.Bounds()
+	px := int64(b.Dx()) * int64(b.Dy())
+
+	switch img.(type) {
+	case *image.Gray, *image.Alpha, *image.Paletted:
+		return px
+	case *image.Gray16, *image.Alpha16:
+		return px * 2
+	case *image.YCbCr:
+		return px * 3
+	case *image.RGBA64, *image.NRGBA64:
+		return px * 8
+	case *HDR:
+		return px * 16
+	}
+
+	return px * 4
+}
+
+// prefetcher runs queued jobs with a pool of workers.
+type prefetcher struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	queue []func()
+}
+
+// newPrefetcher returns new prefetcher and starts workers.
+func newPrefetcher(workers int) *prefetcher {
+	p := &prefetcher{}
+	p.cond = sync.NewCond(&p.mu)
+
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+
+	return p
+}
+
+// Set replaces queued jobs, jobs already running are not interrupted.
+func (p *prefetcher) Set(jobs []func()) {
+	p.mu.Lock()
+	p.queue = jobs
+	p.cond.Broadcast()
+	p.mu.Unlock()
+}
+
+// work runs jobs from queue.
+func (p *prefetcher) work() {
+	p.mu.Lock()
+
+	for {
+		for len(p.queue) == 0 {
+			p.cond.Wait()
+		}
+
+		job := p.queue[0]
+		p.queue = p.queue[1:]
+
+		p.mu.Unlock()
+		job()
+		p.mu.Lock()
+	}
+}
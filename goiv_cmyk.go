@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"math"
+)
+
+// adobeSegment is APP14 segment with unknown transform, it marks JPEG as CMYK with inverted values.
+const adobeSegment = "\xff\xee\x00\x0eAdobe\x00\x64\x00\x00\x00\x00\x00"
+
+// decodeCMYK decodes CMYK or YCCK JPEG, it returns nil if data is not 4-component JPEG.
+// Values are inverted in JPEG with Adobe APP14 segment, as written by Photoshop, and plain in JPEG without it,
+// which stdlib decoder does not support, so segment is added and values are inverted back.
+func decodeCMYK(ctx context.Context, data []byte) (*image.CMYK, error) {
+	components := 0
+	for _, m := range []byte{0xc0, 0xc1, 0xc2} {
+		if s := jpegSegments(data, m); len(s) > 0 && len(s[0]) >= 6 {
+			components = int(s[0][5])
+			break
+		}
+	}
+
+	if components != 4 {
+		return nil, nil
+	}
+
+	adobe := false
+	for _, s := range jpegSegments(data, 0xee) {
+		if bytes.HasPrefix(s, []byte("Adobe")) && len(s) >= 12 {
+			adobe = true
+		}
+	}
+
+	d := data
+	if !adobe {
+		d = append(append([]byte(data[:2:2]), adobeSegment...), data[2:]...)
+	}
+
+	img, err := jpeg.Decode(newReader(ctx, bytes.NewReader(d), int64(len(d)), nil))
+	if err != nil {
+		return nil, err
+	}
+
+	c, ok := img.(*image.CMYK)
+	if !ok {
+		return nil, fmt.Errorf("jpeg: unexpected %T", img)
+	}
+
+	if !adobe {
+		for i, v := range c.Pix {
+			c.Pix[i] = 255 - v
+		}
+	}
+
+	return c, nil
+}
+
+// iccLUT converts CMYK to profile connection space, with input curves, grid of samples and output curves.
+type iccLUT struct {
+	in   [4][]float64
+	grid [4]int
+	clut []float64 // samples of outputs, first input varies slowest
+
+	// lutAtoB has curves, matrix and curves after grid, lut8 and lut16 only curves
+	mcurves [3][]float64
+	matrix  []float64
+	out     [3][]float64
+
+	lab   bool
+	scale float64 // scale of encoded outputs to L*, a*, b* or X, Y, Z
+}
+
+// parseLUT parses lut8, lut16 or lutAtoB tag with 4 inputs and 3 outputs, pcs is profile connection space.
+func parseLUT(tag []byte, pcs string) (*iccLUT, error) {
+	be := binary.BigEndian
+
+	if len(tag) < 32 || tag[8] != 4 || tag[9] != 3 {
+		return nil, fmt.Errorf("icc: unsupported profile, missing lookup table")
+	}
+
+	l := &iccLUT{lab: pcs == "Lab "}
+
+	switch string(tag[:4]) {
+	case "mft1", "mft2":
+		// 8-bit or 16-bit tables, in and out curves have n and m entries
+		size, n, m, off := 1, 256, 256, 48
+		if string(tag[:4]) == "mft2" {
+			if len(tag) < 52 {
+				return nil, fmt.Errorf("icc: invalid lookup table")
+			}
+
+			size, n, m, off = 2, int(be.Uint16(tag[48:])), int(be.Uint16(tag[50:])), 52
+		}
+
+		g := int(tag[10])
+		cells := g * g * g * g
+		if g < 2 || n < 2 || m < 2 || len(tag) < off+(4*n+cells*3+3*m)*size {
+			return nil, fmt.Errorf("icc: invalid lookup table")
+		}
+
+		read := func(i int) float64 {
+			if size == 1 {
+				return float64(tag[off+i]) / 0xff
+			}
+			return float64(be.Uint16(tag[off+i*2:])) / 0xffff
+		}
+
+		table := func(n int) []float64 {
+			t := make([]float64, n)
+			for i := range t {
+				t[i] = read(i)
+			}
+			off += n * size
+
+			return sampleCurve(func(x float64) float64 {
+				f := x * float64(n-1)
+				i := int(f)
+				if i >= n-1 {
+					return t[n-1]
+				}
+				return t[i] + (t[i+1]-t[i])*(f-float64(i))
+			})
+		}
+
+		for i := range l.in {
+			l.in[i] = table(n)
+			l.grid[i] = g
+		}
+
+		l.clut = make([]float64, cells*3)
+		for i := range l.clut {
+			l.clut[i] = read(i)
+		}
+		off += cells * 3 * size
+
+		for i := range l.out {
+			l.out[i] = table(m)
+		}
+
+		// legacy encoding of lut16, 0xff00 is L* 100
+		l.scale = 1
+		if size == 2 && l.lab {
+			l.scale = 0xffff / 65280.0
+		}
+	case "mAB ":
+		offsets := make([]int, 5)
+		for i := range offsets {
+			offsets[i] = int(be.Uint32(tag[12+i*4:]))
+			if offsets[i] < 0 || offsets[i] > len(tag) {
+				return nil, fmt.Errorf("icc: invalid lookup table")
+			}
+		}
+
+		b, mat, m, clut, a := offsets[0], offsets[1], offsets[2], offsets[3], offsets[4]
+		if b == 0 || clut == 0 || a == 0 {
+			return nil, fmt.Errorf("icc: unsupported lookup table")
+		}
+
+		curves := func(off, n int) ([][]float64, error) {
+			c := make([][]float64, n)
+			for i := range c {
+				if off >= len(tag) {
+					return nil, fmt.Errorf("icc: invalid lookup table")
+				}
+
+				var err error
+				c[i], err = iccCurve(tag[off:])
+				if err != nil {
+					return nil, err
+				}
+
+				off += curveLength(tag[off:])
+			}
+
+			return c, nil
+		}
+
+		in, err := curves(a, 4)
+		if err != nil {
+			return nil, err
+		}
+		copy(l.in[:], in)
+
+		out, err := curves(b, 3)
+		if err != nil {
+			return nil, err
+		}
+		copy(l.out[:], out)
+
+		if m != 0 && mat != 0 {
+			mc, err := curves(m, 3)
+			if err != nil {
+				return nil, err
+			}
+			copy(l.mcurves[:], mc)
+
+			if mat+48 > len(tag) {
+				return nil, fmt.Errorf("icc: invalid lookup table")
+			}
+
+			l.matrix = make([]float64, 12)
+			for i := range l.matrix {
+				l.matrix[i] = s15Fixed16(tag[mat+i*4:])
+			}
+		}
+
+		if clut+20 > len(tag) {
+			return nil, fmt.Errorf("icc: invalid lookup table")
+		}
+
+		cells := 1
+		for i := range l.grid {
+			l.grid[i] = int(tag[clut+i])
+			if l.grid[i] < 2 {
+				return nil, fmt.Errorf("icc: invalid lookup table")
+			}
+
+			cells *= l.grid[i]
+		}
+
+		size := int(tag[clut+16])
+		if (size != 1 && size != 2) || clut+20+cells*3*size > len(tag) {
+			return nil, fmt.Errorf("icc: invalid lookup table")
+		}
+
+		l.clut = make([]float64, cells*3)
+		for i := range l.clut {
+			if size == 1 {
+				l.clut[i] = float64(tag[clut+20+i]) / 0xff
+			} else {
+				l.clut[i] = float64(be.Uint16(tag[clut+20+i*2:])) / 0xffff
+			}
+		}
+
+		l.scale = 1
+	default:
+		return nil, fmt.Errorf("icc: unsupported lookup table %s", tag[:4])
+	}
+
+	return l, nil
+}
+
+// curveLength returns size of curve or parametric curve tag, padded to 4 bytes.
+func curveLength(tag []byte) int {
+	n := len(tag)
+	switch string(tag[:4]) {
+	case "curv":
+		n = 12 + int(binary.BigEndian.Uint32(tag[8:]))*2
+	case "para":
+		n = 12 + []int{1, 3, 4, 5, 7}[binary.BigEndian.Uint16(tag[8:])]*4
+	}
+
+	return (n + 3) &^ 3
+}
+
+// gridPosition is offset of sample below input value in grid, and fraction of distance to next sample.
+type gridPosition struct {
+	offset int
+	frac   float64
+}
+
+// positions returns grid positions of 8-bit input values, and offsets of next samples.
+func (l *iccLUT) positions() (pos [4][256]gridPosition, step [4]int) {
+	stride := 3
+	for i := 3; i >= 0; i-- {
+		g := l.grid[i]
+		for v := range pos[i] {
+			f := linear(l.in[i], math.Max(0, math.Min(1, float64(v)/0xff))) * float64(g-1)
+			n := int(f)
+			if n >= g-1 {
+				n = g - 2
+			}
+
+			pos[i][v] = gridPosition{n * stride, f - float64(n)}
+		}
+
+		step[i] = stride
+		stride *= g
+	}
+
+	return pos, step
+}
+
+// xyz converts CMYK at grid positions to XYZ relative to D50, it is interpolated in simplex of 5 nearest samples.
+func (l *iccLUT) xyz(pos [4]gridPosition, step [4]int) [3]float64 {
+	// inputs sorted by fraction, descending
+	order := [4]int{0, 1, 2, 3}
+	for i := 1; i < 4; i++ {
+		for j := i; j > 0 && pos[order[j]].frac > pos[order[j-1]].frac; j-- {
+			order[j], order[j-1] = order[j-1], order[j]
+		}
+	}
+
+	var v [3]float64
+	w, at := 1.0, pos[0].offset+pos[1].offset+pos[2].offset+pos[3].offset
+	for _, i := range order {
+		for c := range v {
+			v[c] += (w - pos[i].frac) * l.clut[at+c]
+		}
+
+		w = pos[i].frac
+		at += step[i]
+	}
+
+	for c := range v {
+		v[c] += w * l.clut[at+c]
+	}
+
+	if l.matrix != nil {
+		for c := range v {
+			v[c] = linear(l.mcurves[c], math.Max(0, math.Min(1, v[c])))
+		}
+
+		m := l.matrix
+		v = [3]float64{
+			m[0]*v[0] + m[1]*v[1] + m[2]*v[2] + m[9],
+			m[3]*v[0] + m[4]*v[1] + m[5]*v[2] + m[10],
+			m[6]*v[0] + m[7]*v[1] + m[8]*v[2] + m[11],
+		}
+	}
+
+	for c := range v {
+		v[c] = linear(l.out[c], math.Max(0, math.Min(1, v[c]))) * l.scale
+	}
+
+	if !l.lab {
+		// XYZ is encoded as 1.15 fixed point
+		return [3]float64{v[0] * 0xffff / 0x8000, v[1] * 0xffff / 0x8000, v[2] * 0xffff / 0x8000}
+	}
+
+	// L*a*b* relative to D50
+	fy := (v[0]*100 + 16) / 116
+	fx := fy + (v[1]*255-128)/500
+	fz := fy - (v[2]*255-128)/200
+
+	finv := func(f float64) float64 {
+		if f > 6.0/29 {
+			return f * f * f
+		}
+		return 3 * (6.0 / 29) * (6.0 / 29) * (f - 4.0/29)
+	}
+
+	return [3]float64{d50[0] * finv(fx), d50[1] * finv(fy), d50[2] * finv(fz)}
+}
+
+// convertCMYK returns CMYK image converted with lookup table of profile to display profile.
+func (p *Profile) convertCMYK(img image.Image, display *Profile) image.Image {
+	src, ok := img.(*image.CMYK)
+	if !ok {
+		return img
+	}
+
+	b := src.Bounds()
+	dst := image.NewRGBA(b)
+
+	pos, step := p.lut.positions()
+
+	// neighbouring pixels often have the same color
+	var last [4]uint8
+	var out [4]uint8
+	first := true
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			i := src.PixOffset(x, y)
+			var c [4]uint8
+			copy(c[:], src.Pix[i:i+4])
+
+			if first || c != last {
+				xyz := p.lut.xyz([4]gridPosition{pos[0][c[0]], pos[1][c[1]], pos[2][c[2]], pos[3][c[3]]}, step)
+
+				for j := 0; j < 3; j++ {
+					m := display.fromXYZ[j]
+					v := m[0]*xyz[0] + m[1]*xyz[1] + m[2]*xyz[2]
+					out[j] = uint8((uint32(display.inverse[j][int(math.Max(0, math.Min(1, v))*0xffff+0.5)]) + 0x80) / 0x101)
+				}
+				out[3] = 0xff
+
+				last, first = c, false
+			}
+
+			copy(dst.Pix[dst.PixOffset(x, y):], out[:])
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// cmykColors are colors of 8x8 blocks in CMYK fixtures: white, cyan, red, black and 50% black.
+var cmykColors = []color.RGBA{
+	{255, 255, 255, 255},
+	{0, 255, 255, 255},
+	{255, 0, 0, 255},
+	{0, 0, 0, 255},
+	{127, 127, 127, 255},
+}
+
+// checkColors compares centers of 8x8 blocks with colors.
+func checkColors(t *testing.T, img image.Image, colors []color.RGBA) {
+	t.Helper()
+
+	for i, want := range colors {
+		r, g, b, _ := img.At(i*8+4, 4).RGBA()
+		got := color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), 255}
+
+		for _, d := range []int{int(got.R) - int(want.R), int(got.G) - int(want.G), int(got.B) - int(want.B)} {
+			if d < -3 || d > 3 {
+				t.Errorf("block %d: got %v, want %v", i, got, want)
+				break
+			}
+		}
+	}
+}
+
+func TestDecodeCMYK(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+	}{
+		{"plain", "cmyk.jpg"},
+		{"adobe inverted", "cmyk_adobe.jpg"},
+		{"ycck", "ycck.jpg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := ioutil.ReadFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			img, err := decodeCMYK(context.Background(), data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if img == nil {
+				t.Fatal("not decoded as CMYK")
+			}
+
+			checkColors(t, img, cmykColors)
+		})
+	}
+}
+
+func TestDecodeCMYKProfile(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "cmyk_profile.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := decodeCMYK(context.Background(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tagged := withProfile(img, data)
+	if _, p := untag(tagged); p == nil || p.lut == nil {
+		t.Fatal("embedded CMYK profile not found")
+	}
+
+	// profile swaps cyan and yellow inks, 50% black is linear in XYZ
+	checkColors(t, manage(tagged, sRGB), []color.RGBA{
+		{255, 255, 255, 255},
+		{255, 255, 0, 255},
+		{0, 0, 255, 255},
+		{0, 0, 0, 255},
+		{187, 187, 187, 255},
+	})
+}
+
+func TestDecodeCMYKOther(t *testing.T) {
+	img, err := decodeCMYK(context.Background(), []byte("\x89PNG\r\n\x1a\n"))
+	if err != nil || img != nil {
+		t.Errorf("got %v, %v, want nil", img, err)
+	}
+}
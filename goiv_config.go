@@ -0,0 +1,560 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options holds settings from command line flags and config file.
+type Options struct {
+	Width  int
+	Height int
+
+	Mode    ViewMode
+	Filter  Filter
+	Preview bool
+	Exif    bool
+	Animate bool
+
+	Exposure float64
+	ToneMap  ToneMap
+	Dither   bool
+
+	ICC     bool
+	Profile *Profile
+
+	Cache    int64
+	Prefetch int
+
+	Slideshow time.Duration
+	Loop      bool
+	Shuffle   bool
+	Keys      map[string]Command
+
+	// keys set in config, applied over preset
+	bound map[string]Command
+
+	Decoders []Decoder
+	HTTP     *Client
+
+	// titles and captions of images from lists
+	Entries map[string]Entry
+
+	// index of first shown image
+	Start int
+}
+
+// actionNames maps action names used in config file to actions.
+var actionNames = map[string]Action{
+	"none":       ActionNone,
+	"next":       ActionNext,
+	"prev":       ActionPrev,
+	"first":      ActionFirst,
+	"last":       ActionLast,
+	"fullscreen": ActionFullscreen,
+	"print":      ActionPrint,
+	"quit":       ActionQuit,
+	"fit":        ActionFit,
+	"fit-width":  ActionFitWidth,
+	"fit-height": ActionFitHeight,
+	"actual":     ActionActual,
+	"shrink":     ActionShrink,
+	"zoom-in":    ActionZoomIn,
+	"zoom-out":   ActionZoomOut,
+	"pan-left":   ActionPanLeft,
+	"pan-right":  ActionPanRight,
+	"pan-up":     ActionPanUp,
+	"pan-down":   ActionPanDown,
+	"filter":     ActionFilter,
+	"rotate-cw":  ActionRotateCW,
+	"rotate-ccw": ActionRotateCCW,
+	"flip-h":     ActionFlipHorizontal,
+	"flip-v":     ActionFlipVertical,
+	"slideshow":  ActionSlideshow,
+	"faster":     ActionSlideshowFaster,
+	"slower":     ActionSlideshowSlower,
+	"pause":      ActionPause,
+	"frame-next": ActionFrameNext,
+	"frame-prev": ActionFramePrev,
+	"anim-fast":  ActionPlaybackFaster,
+	"anim-slow":  ActionPlaybackSlower,
+	"page-next":  ActionPageNext,
+	"page-prev":  ActionPagePrev,
+	"layer-next": ActionLayerNext,
+	"layer-prev": ActionLayerPrev,
+	"layer":      ActionLayerToggle,
+	"exp-up":     ActionExposureUp,
+	"exp-down":   ActionExposureDown,
+	"exp-reset":  ActionExposureReset,
+	"tonemap":    ActionToneMap,
+	"icc":        ActionICC,
+}
+
+// viewModes maps view mode names to view modes.
+var viewModes = map[string]ViewMode{
+	"fit":        ViewFit,
+	"fit-width":  ViewFitWidth,
+	"fit-height": ViewFitHeight,
+	"actual":     ViewActual,
+	"shrink":     ViewShrink,
+}
+
+// filterNames maps filters to names.
+var filterNames = map[Filter]string{
+	FilterAuto:     "auto",
+	FilterNearest:  "nearest",
+	FilterBilinear: "bilinear",
+	FilterBicubic:  "bicubic",
+	FilterMitchell: "mitchell",
+	FilterLanczos2: "lanczos2",
+	FilterLanczos3: "lanczos3",
+}
+
+// parseFilter returns filter for name.
+func parseFilter(name string) (Filter, error) {
+	for f, n := range filterNames {
+		if n == name {
+			return f, nil
+		}
+	}
+
+	return FilterAuto, fmt.Errorf("unknown filter %s", name)
+}
+
+// parseSize parses size in bytes with optional K, M or G suffix.
+func parseSize(s string) (int64, error) {
+	if s == "" {
+		return 0, fmt.Errorf("invalid size")
+	}
+
+	shift := uint(0)
+
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		shift = 10
+	case "M":
+		shift = 20
+	case "G":
+		shift = 30
+	}
+
+	if shift != 0 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %s", s)
+	}
+
+	return n << shift, nil
+}
+
+// keyNames maps X11 keysym names of punctuation keys, and other names of keysyms with more than one name, to key names.
+var keyNames = map[string]string{
+	"bracketleft":  "[",
+	"bracketright": "]",
+	"comma":        ",",
+	"period":       ".",
+	"plus":         "+",
+	"minus":        "-",
+	"equal":        "=",
+	"slash":        "/",
+	"backslash":    "\\",
+	"less":         "<",
+	"greater":      ">",
+	"numbersign":   "#",
+	"semicolon":    ";",
+	"apostrophe":   "'",
+	"braceleft":    "{",
+	"braceright":   "}",
+	// keysyms with more than one name
+	"Prior":    "Page_Up",
+	"Next":     "Page_Down",
+	"KP_Prior": "KP_Page_Up",
+	"KP_Next":  "KP_Page_Down",
+	"L1":       "F11",
+	"L2":       "F12",
+	"L3":       "F13",
+	"L4":       "F14",
+	"L5":       "F15",
+	"L6":       "F16",
+	"L7":       "F17",
+	"L8":       "F18",
+	"L9":       "F19",
+	"L10":      "F20",
+	"R1":       "F21",
+	"R2":       "F22",
+	"R3":       "F23",
+	"R4":       "F24",
+	"R5":       "F25",
+	"R6":       "F26",
+	"R7":       "F27",
+	"R8":       "F28",
+	"R9":       "F29",
+	"R10":      "F30",
+	"R11":      "F31",
+	"R12":      "F32",
+	"R13":      "F33",
+	"R14":      "F34",
+	"R15":      "F35",
+}
+
+// defaultKeys are default keybindings.
+var defaultKeys = map[string]Command{
+	"j":              {Action: ActionNext},
+	"Right":          {Action: ActionNext},
+	"Page_Down":      {Action: ActionNext},
+	"space":          {Action: ActionNext},
+	"Button1":        {Action: ActionNext},
+	"k":              {Action: ActionPrev},
+	"Left":           {Action: ActionPrev},
+	"Page_Up":        {Action: ActionPrev},
+	"Button3":        {Action: ActionPrev},
+	"]":              {Action: ActionSkip, Count: 10},
+	"[":              {Action: ActionSkip, Count: -10},
+	",":              {Action: ActionFirst},
+	".":              {Action: ActionLast},
+	"f":              {Action: ActionFullscreen},
+	"F11":            {Action: ActionFullscreen},
+	"Return":         {Action: ActionPrint},
+	"q":              {Action: ActionQuit},
+	"Escape":         {Action: ActionQuit},
+	"ctrl+c":         {Action: ActionQuit},
+	"+":              {Action: ActionZoomIn},
+	"=":              {Action: ActionZoomIn},
+	"-":              {Action: ActionZoomOut},
+	"Button4":        {Action: ActionZoomIn},
+	"Button5":        {Action: ActionZoomOut},
+	"z":              {Action: ActionFit},
+	"w":              {Action: ActionFitWidth},
+	"e":              {Action: ActionFitHeight},
+	"1":              {Action: ActionActual},
+	"s":              {Action: ActionShrink},
+	"i":              {Action: ActionFilter},
+	"r":              {Action: ActionRotateCW},
+	"R":              {Action: ActionRotateCCW},
+	"m":              {Action: ActionFlipHorizontal},
+	"v":              {Action: ActionFlipVertical},
+	"p":              {Action: ActionSlideshow},
+	"F5":             {Action: ActionSlideshow},
+	"}":              {Action: ActionSlideshowFaster},
+	"{":              {Action: ActionSlideshowSlower},
+	"H":              {Action: ActionPanLeft},
+	"L":              {Action: ActionPanRight},
+	"K":              {Action: ActionPanUp},
+	"J":              {Action: ActionPanDown},
+	"a":              {Action: ActionPause},
+	"n":              {Action: ActionFrameNext},
+	"b":              {Action: ActionFramePrev},
+	">":              {Action: ActionPlaybackFaster},
+	"<":              {Action: ActionPlaybackSlower},
+	"ctrl+Page_Down": {Action: ActionPageNext},
+	"ctrl+Page_Up":   {Action: ActionPagePrev},
+	"ctrl+j":         {Action: ActionPageNext},
+	"ctrl+k":         {Action: ActionPagePrev},
+	"ctrl+n":         {Action: ActionLayerNext},
+	"ctrl+p":         {Action: ActionLayerPrev},
+	"t":              {Action: ActionLayerToggle},
+	"x":              {Action: ActionExposureUp},
+	"X":              {Action: ActionExposureDown},
+	"0":              {Action: ActionExposureReset},
+	"T":              {Action: ActionToneMap},
+	"c":              {Action: ActionICC},
+}
+
+// presets are predefined keybindings merged onto default keys, selected with preset in [keys] section.
+var presets = map[string]map[string]Command{
+	"default": {},
+	"vi": {
+		"l":      {Action: ActionNext},
+		"h":      {Action: ActionPrev},
+		"ctrl+f": {Action: ActionSkip, Count: 10},
+		"ctrl+b": {Action: ActionSkip, Count: -10},
+		"g":      {Action: ActionFirst},
+		"G":      {Action: ActionLast},
+	},
+	"emacs": {
+		"ctrl+n": {Action: ActionNext},
+		"ctrl+f": {Action: ActionNext},
+		"ctrl+p": {Action: ActionPrev},
+		"ctrl+b": {Action: ActionPrev},
+		"ctrl+v": {Action: ActionSkip, Count: 10},
+		"alt+v":  {Action: ActionSkip, Count: -10},
+		"alt+<":  {Action: ActionFirst},
+		"alt+>":  {Action: ActionLast},
+		"ctrl+g": {Action: ActionQuit},
+		"alt+z":  {Action: ActionFit},
+		"alt+w":  {Action: ActionFitWidth},
+		"alt+h":  {Action: ActionFitHeight},
+		"alt+1":  {Action: ActionActual},
+		"alt+s":  {Action: ActionShrink},
+		"alt+i":  {Action: ActionFilter},
+		"alt+r":  {Action: ActionRotateCW},
+		"alt+R":  {Action: ActionRotateCCW},
+		"alt+m":  {Action: ActionFlipHorizontal},
+		"alt+y":  {Action: ActionFlipVertical},
+		"alt+b":  {Action: ActionPanLeft},
+		"alt+f":  {Action: ActionPanRight},
+		"alt+p":  {Action: ActionPanUp},
+		"alt+n":  {Action: ActionPanDown},
+		"alt+a":  {Action: ActionPause},
+		"alt+]":  {Action: ActionFrameNext},
+		"alt+[":  {Action: ActionFramePrev},
+		"alt+}":  {Action: ActionPlaybackFaster},
+		"alt+{":  {Action: ActionPlaybackSlower},
+		"alt+l":  {Action: ActionLayerNext},
+		"alt+L":  {Action: ActionLayerPrev},
+		"alt+t":  {Action: ActionLayerToggle},
+		"alt+e":  {Action: ActionExposureUp},
+		"alt+E":  {Action: ActionExposureDown},
+		"alt+0":  {Action: ActionExposureReset},
+		"alt+T":  {Action: ActionToneMap},
+		"alt+c":  {Action: ActionICC},
+	},
+	// arrows is used without default keys, there are no letter keys
+	"arrows": {
+		"Right":          {Action: ActionNext},
+		"Down":           {Action: ActionNext},
+		"Left":           {Action: ActionPrev},
+		"Up":             {Action: ActionPrev},
+		"Page_Down":      {Action: ActionSkip, Count: 10},
+		"Page_Up":        {Action: ActionSkip, Count: -10},
+		"Home":           {Action: ActionFirst},
+		"End":            {Action: ActionLast},
+		"ctrl+Left":      {Action: ActionPanLeft},
+		"ctrl+Right":     {Action: ActionPanRight},
+		"ctrl+Up":        {Action: ActionPanUp},
+		"ctrl+Down":      {Action: ActionPanDown},
+		"ctrl+Page_Down": {Action: ActionPageNext},
+		"ctrl+Page_Up":   {Action: ActionPagePrev},
+		"Button1":        {Action: ActionNext},
+		"Button3":        {Action: ActionPrev},
+		"Button4":        {Action: ActionZoomIn},
+		"Button5":        {Action: ActionZoomOut},
+		"+":              {Action: ActionZoomIn},
+		"-":              {Action: ActionZoomOut},
+		"F5":             {Action: ActionSlideshow},
+		"F11":            {Action: ActionFullscreen},
+		"Return":         {Action: ActionPrint},
+		"Escape":         {Action: ActionQuit},
+		"ctrl+c":         {Action: ActionQuit},
+	},
+}
+
+// defaultOptions returns options with default keybindings.
+func defaultOptions() *Options {
+	o := &Options{}
+	o.Width = 1024
+	o.Height = 768
+	o.Mode = ViewFit
+	o.Filter = FilterAuto
+	o.Preview = true
+	o.Exif = true
+	o.Animate = true
+	o.ICC = true
+	o.Cache = 256 << 20
+	o.Prefetch = 2
+	o.Keys = preset("default")
+	o.HTTP = newClient()
+	o.Entries = make(map[string]Entry)
+
+	return o
+}
+
+// standalone are presets used without default keys.
+var standalone = map[string]bool{
+	"arrows": true,
+}
+
+// preset returns copy of default keybindings with preset keybindings merged onto them.
+func preset(name string) map[string]Command {
+	keys := make(map[string]Command)
+	if !standalone[name] {
+		for k, c := range defaultKeys {
+			keys[k] = c
+		}
+	}
+
+	for k, c := range presets[name] {
+		keys[k] = c
+	}
+
+	return keys
+}
+
+// configPath returns path to config file, $XDG_CONFIG_HOME/goiv/config.
+func configPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, appName, "config")
+}
+
+// loadConfig reads config file into options, missing file is not an error.
+func loadConfig(filename string, o *Options) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	defer file.Close()
+
+	err = readConfig(file, o)
+	if err != nil {
+		return fmt.Errorf("%s: %s", filename, err.Error())
+	}
+
+	return nil
+}
+
+// readConfig reads config from reader.
+//
+// Config has sections and name = value lines, lines starting with # are comments:
+//
+//	[keys]
+//	preset = vi
+//	x = quit
+//	bracketleft = skip-10
+//
+//	[decoders]
+//	image/heic = heif-convert %f %o
+//	0x49492a00????4352 = dcraw -c %f
+//
+//	[http]
+//	timeout = 10s
+//	retries = 3
+//	token = secret
+//	Cookie = session=1
+func readConfig(r io.Reader, o *Options) error {
+	section := ""
+
+	n := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		n++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			return fmt.Errorf("line %d: expected name = value", n)
+		}
+
+		name := strings.TrimSpace(line[:i])
+		value := strings.TrimSpace(line[i+1:])
+
+		var err error
+		switch section {
+		case "keys":
+			err = o.setKey(name, value)
+		case "decoders":
+			var d Decoder
+			d, err = parseDecoder(name, value)
+			o.Decoders = append(o.Decoders, d)
+		case "http":
+			err = o.HTTP.set(name, value)
+		default:
+			err = fmt.Errorf("unknown section [%s]", section)
+		}
+
+		if err != nil {
+			return fmt.Errorf("line %d: %s", n, err.Error())
+		}
+	}
+
+	return scanner.Err()
+}
+
+// setKey sets keybinding, or selects preset. Preset is base of keybindings, keys set before it are kept.
+func (o *Options) setKey(name, value string) error {
+	if name == "preset" {
+		if _, ok := presets[value]; !ok {
+			return fmt.Errorf("unknown preset %s", value)
+		}
+
+		o.Keys = preset(value)
+		for k, c := range o.bound {
+			o.bind(k, c)
+		}
+
+		return nil
+	}
+
+	c, err := parseCommand(value)
+	if err != nil {
+		return err
+	}
+
+	name = keyName(name)
+
+	if o.bound == nil {
+		o.bound = make(map[string]Command)
+	}
+	o.bound[name] = c
+
+	o.bind(name, c)
+
+	return nil
+}
+
+// bind sets keybinding, none removes it.
+func (o *Options) bind(name string, c Command) {
+	if c.Action == ActionNone {
+		delete(o.Keys, name)
+	} else {
+		o.Keys[name] = c
+	}
+}
+
+// keyName returns key name for name used in config, with modifiers preserved.
+func keyName(name string) string {
+	prefix := ""
+	if i := strings.LastIndex(name, "+"); i > 0 && i < len(name)-1 {
+		prefix, name = name[:i+1], name[i+1:]
+	}
+
+	if n, ok := keyNames[name]; ok {
+		name = n
+	}
+
+	return prefix + name
+}
+
+// parseCommand parses action name, skip takes a signed count, i.e. skip+10.
+func parseCommand(s string) (Command, error) {
+	if strings.HasPrefix(s, "skip") {
+		n, err := strconv.Atoi(strings.TrimPrefix(s, "skip"))
+		if err != nil {
+			return Command{}, fmt.Errorf("invalid skip count %s", s)
+		}
+
+		return Command{Action: ActionSkip, Count: n}, nil
+	}
+
+	a, ok := actionNames[s]
+	if !ok {
+		return Command{}, fmt.Errorf("unknown action %s", s)
+	}
+
+	return Command{Action: a}, nil
+}
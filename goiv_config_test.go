@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPreset(t *testing.T) {
+	tests := []struct {
+		preset string
+		key    string
+		action Action
+	}{
+		{"default", "j", ActionNext},
+		{"vi", "g", ActionFirst},
+		{"vi", "j", ActionNext},
+		{"vi", ",", ActionFirst},
+		{"emacs", "ctrl+n", ActionNext},
+		{"emacs", "alt+l", ActionLayerNext},
+		{"emacs", "q", ActionQuit},
+		{"arrows", "Page_Down", ActionSkip},
+		{"arrows", "Home", ActionFirst},
+		{"arrows", "ctrl+Left", ActionPanLeft},
+		{"arrows", "j", ActionNone},
+		{"arrows", "l", ActionNone},
+		{"arrows", "L", ActionNone},
+	}
+
+	for _, tt := range tests {
+		o := defaultOptions()
+		if err := o.setKey("preset", tt.preset); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := o.Keys[tt.key].Action; got != tt.action {
+			t.Errorf("%s %s: got action %v, want %v", tt.preset, tt.key, got, tt.action)
+		}
+	}
+
+	// preset is copied, changes are not kept in default keys
+	o := defaultOptions()
+	o.Keys["j"] = Command{Action: ActionQuit}
+
+	if defaultKeys["j"].Action != ActionNext {
+		t.Error("default keys changed")
+	}
+}
+
+func TestConfigPreset(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		key    string
+		action Action
+	}{
+		{"key after preset", "[keys]\npreset = arrows\nx = quit\n", "x", ActionQuit},
+		{"key before preset", "[keys]\nx = quit\npreset = arrows\n", "x", ActionQuit},
+		{"unbound before preset", "[keys]\nRight = none\npreset = arrows\n", "Right", ActionNone},
+		{"preset keys", "[keys]\nx = quit\npreset = arrows\n", "Left", ActionPrev},
+		{"default keys not in preset", "[keys]\nx = quit\npreset = arrows\n", "j", ActionNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := defaultOptions()
+			if err := readConfig(strings.NewReader(tt.config), o); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := o.Keys[tt.key].Action; got != tt.action {
+				t.Errorf("%s: got action %v, want %v", tt.key, got, tt.action)
+			}
+		})
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// crawler collects images from HTML pages and directory listings.
+type crawler struct {
+	ctx      context.Context
+	client   *Client
+	depth    int
+	sameHost bool
+
+	visited map[string]bool
+	seen    map[string]bool
+	images  []string
+
+	// pages is number of crawled pages, reported to terminal
+	pages    int
+	terminal bool
+}
+
+// crawl replaces URLs of HTML pages in args with images found on them, other arguments are kept.
+// Links to pages are followed up to depth levels, with sameHost only links to host of the page are used.
+// Crawling stops when context is done, images found until then are returned.
+func crawl(ctx context.Context, args []string, client *Client, depth int, sameHost bool) []string {
+	c := &crawler{ctx: ctx, client: client, depth: depth, sameHost: sameHost, visited: make(map[string]bool), seen: make(map[string]bool)}
+
+	if fi, err := os.Stderr.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		c.terminal = true
+	}
+
+	for _, arg := range args {
+		if ctx.Err() != nil {
+			break
+		}
+
+		u, err := url.Parse(arg)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || hasImageExt(u.Path) {
+			c.add(arg)
+			continue
+		}
+
+		ok, err := c.page(u, 0)
+		if err != nil && ctx.Err() == nil {
+			c.error(err)
+		}
+
+		if err != nil {
+			continue
+		}
+
+		// URL without extension can be image
+		if !ok {
+			c.add(arg)
+		}
+	}
+
+	if ctx.Err() != nil {
+		c.error(fmt.Errorf("crawl: interrupted, %d images found", len(c.images)))
+	} else if c.terminal && c.pages > 0 {
+		fmt.Fprintf(os.Stderr, "\n")
+	}
+
+	return c.images
+}
+
+// report prints number of crawled pages and found images to terminal.
+func (c *crawler) report() {
+	if c.terminal {
+		fmt.Fprintf(os.Stderr, "\rcrawl: %d pages, %d images", c.pages, len(c.images))
+	}
+}
+
+// error prints error, over progress in terminal.
+func (c *crawler) error(err error) {
+	if c.terminal {
+		fmt.Fprintf(os.Stderr, "\r\x1b[K")
+	}
+
+	fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+}
+
+// add appends image to list, duplicates are skipped.
+func (c *crawler) add(image string) {
+	if !c.seen[image] {
+		c.seen[image] = true
+		c.images = append(c.images, image)
+	}
+}
+
+// page adds images from page and pages linked from it at level, it reports if page is HTML.
+func (c *crawler) page(u *url.URL, level int) (bool, error) {
+	c.visited[u.String()] = true
+
+	data, final, err := c.client.Fetch(c.ctx, u.String(), nil)
+	if err != nil {
+		return false, err
+	}
+
+	if !strings.HasPrefix(http.DetectContentType(data), "text/html") {
+		return false, nil
+	}
+
+	c.pages++
+
+	base, err := url.Parse(final)
+	if err != nil {
+		return true, err
+	}
+
+	images, pages := htmlLinks(string(data), base)
+
+	for _, i := range images {
+		if c.allowed(u, i) {
+			c.add(i.String())
+		}
+	}
+
+	c.report()
+
+	if level >= c.depth {
+		return true, nil
+	}
+
+	for _, p := range pages {
+		if c.ctx.Err() != nil {
+			break
+		}
+
+		// parent directories and sorting of listings are not followed
+		if !c.allowed(u, p) || c.visited[p.String()] || (p.Host == base.Host && strings.HasPrefix(base.Path, p.Path)) {
+			continue
+		}
+
+		if _, err := c.page(p, level+1); err != nil && c.ctx.Err() == nil {
+			c.error(err)
+		}
+	}
+
+	return true, nil
+}
+
+// allowed checks if link from page can be used.
+func (c *crawler) allowed(page, link *url.URL) bool {
+	return !c.sameHost || strings.EqualFold(page.Host, link.Host)
+}
+
+// htmlLinks returns URLs of images in img src, srcset and a href to image files, and URLs of other linked pages.
+func htmlLinks(data string, base *url.URL) ([]*url.URL, []*url.URL) {
+	images := make([]*url.URL, 0)
+	pages := make([]*url.URL, 0)
+	based := false
+
+	resolve := func(ref string) *url.URL {
+		u, err := base.Parse(strings.TrimSpace(ref))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil
+		}
+
+		u.Fragment = ""
+		return u
+	}
+
+	htmlTags(data, func(name string, attrs map[string]string) {
+		switch name {
+		case "base":
+			// only first base is used
+			if b := resolve(attrs["href"]); b != nil && !based {
+				base, based = b, true
+			}
+		case "img", "source":
+			refs := srcset(attrs["srcset"])
+			if name == "img" && attrs["src"] != "" {
+				refs = append([]string{attrs["src"]}, refs...)
+			}
+
+			for _, r := range refs {
+				if u := resolve(r); u != nil {
+					images = append(images, u)
+				}
+			}
+		case "a":
+			if attrs["href"] == "" {
+				return
+			}
+
+			if u := resolve(attrs["href"]); u != nil && hasImageExt(u.Path) {
+				images = append(images, u)
+			} else if u != nil {
+				pages = append(pages, u)
+			}
+		}
+	})
+
+	return images, pages
+}
+
+// srcset returns URLs of image candidates in srcset attribute, i.e. "a.jpg 1x, b.jpg 2x".
+func srcset(s string) []string {
+	urls := make([]string, 0)
+
+	for s != "" {
+		s = strings.TrimLeft(s, " \t\r\n\f,")
+
+		i := strings.IndexAny(s, " \t\r\n\f")
+		if i < 0 {
+			i = len(s)
+		}
+
+		// comma after URL ends candidate without descriptors
+		if u := strings.TrimRight(s[:i], ","); u != "" {
+			urls = append(urls, u)
+		}
+
+		if strings.HasSuffix(s[:i], ",") {
+			s = s[i:]
+			continue
+		}
+
+		s = s[i:]
+		if j := strings.Index(s, ","); j >= 0 {
+			s = s[j+1:]
+		} else {
+			s = ""
+		}
+	}
+
+	return urls
+}
+
+// htmlTags calls fn with lowercase name and attributes of start tags in HTML, comments and content of script and style are skipped.
+func htmlTags(data string, fn func(name string, attrs map[string]string)) {
+	// ASCII lowercase, with the same offsets as data
+	b := []byte(data)
+	for i, c := range b {
+		if c >= 'A' && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	lower := string(b)
+
+	skip := func(i int, end string) int {
+		j := strings.Index(lower[i:], end)
+		if j < 0 {
+			return len(data)
+		}
+		return i + j + len(end)
+	}
+
+	space := func(b byte) bool {
+		return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\f'
+	}
+
+	for i := 0; i < len(data); {
+		j := strings.IndexByte(data[i:], '<')
+		if j < 0 {
+			return
+		}
+		i += j + 1
+
+		if strings.HasPrefix(data[i:], "!--") {
+			i = skip(i, "-->")
+			continue
+		}
+
+		if i >= len(data) || !(lower[i] >= 'a' && lower[i] <= 'z') {
+			// end tag, doctype or text
+			if i < len(data) && (data[i] == '/' || data[i] == '!' || data[i] == '?') {
+				i = skip(i, ">")
+			}
+			continue
+		}
+
+		start := i
+		for i < len(data) && !space(data[i]) && data[i] != '>' && data[i] != '/' {
+			i++
+		}
+		name := lower[start:i]
+
+		attrs := make(map[string]string)
+		for i < len(data) && data[i] != '>' {
+			if space(data[i]) || data[i] == '/' {
+				i++
+				continue
+			}
+
+			start := i
+			for i < len(data) && !space(data[i]) && data[i] != '>' && data[i] != '/' && data[i] != '=' {
+				i++
+			}
+			key := lower[start:i]
+
+			for i < len(data) && space(data[i]) {
+				i++
+			}
+
+			value := ""
+			if i < len(data) && data[i] == '=' {
+				i++
+				for i < len(data) && space(data[i]) {
+					i++
+				}
+
+				if i < len(data) && (data[i] == '"' || data[i] == '\'') {
+					q := data[i]
+					end := strings.IndexByte(data[i+1:], q)
+					if end < 0 {
+						end = len(data) - i - 1
+					}
+
+					value = data[i+1 : i+1+end]
+					i += end + 2
+					if i > len(data) {
+						i = len(data)
+					}
+				} else {
+					start := i
+					for i < len(data) && !space(data[i]) && data[i] != '>' {
+						i++
+					}
+					value = data[start:i]
+				}
+			}
+
+			if _, ok := attrs[key]; !ok && key != "" {
+				attrs[key] = html.UnescapeString(value)
+			}
+		}
+
+		fn(name, attrs)
+
+		if name == "script" || name == "style" {
+			i = skip(i, "</"+name)
+		}
+	}
+}
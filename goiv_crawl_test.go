@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCrawl(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprintf(w, `<html><img src="a.jpg"><a href="/b/">b</a><a href="/c/">c</a></html>`)
+		case "/b/":
+			fmt.Fprintf(w, `<html><img srcset="b1.jpg 1x, b2.jpg 2x"><a href="/stop/">stop</a></html>`)
+		case "/stop/":
+			// crawling is interrupted while page is loaded
+			cancel()
+			<-r.Context().Done()
+		case "/c/":
+			fmt.Fprintf(w, `<html><img src="c.jpg"></html>`)
+		}
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		depth  int
+		images []string
+	}{
+		{"page", context.Background(), 0, []string{"/a.jpg"}},
+		{"linked pages", context.Background(), 1, []string{"/a.jpg", "/b/b1.jpg", "/b/b2.jpg", "/c/c.jpg"}},
+		{"interrupted", ctx, 2, []string{"/a.jpg", "/b/b1.jpg", "/b/b2.jpg"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testClient(t)
+			c.Retries = 0
+
+			images := crawl(tt.ctx, []string{ts.URL + "/", "local.png"}, c, tt.depth, true)
+
+			want := make([]string, 0)
+			for _, i := range tt.images {
+				want = append(want, ts.URL+i)
+			}
+
+			if tt.ctx.Err() == nil {
+				want = append(want, "local.png")
+			}
+
+			if fmt.Sprint(images) != fmt.Sprint(want) {
+				t.Errorf("got %v, want %v", images, want)
+			}
+		})
+	}
+}
@@ -2,6 +2,6 @@
 
 package main
 
-func display(images []string, width, height int) {
-	displayX11(images, width, height)
+func display(images []string, opts *Options) {
+	displayX11(images, opts)
 }
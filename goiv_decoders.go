@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	// output of converters
+	_ "image/png"
+
+	_ "github.com/jbuchbinder/gopnm"
+)
+
+// Decoder is external converter command for files matching MIME type or magic bytes.
+// Command reads file from stdin, or from temporary file given as %f, and writes PNG or PNM to stdout, or to file given as %o.
+type Decoder struct {
+	MIME    string
+	Magic   string
+	Mask    string
+	Command []string
+}
+
+// mimeTypes maps magic bytes of image formats to MIME types, bytes marked with ? in mask match any byte.
+var mimeTypes = []struct {
+	magic string
+	mask  string
+	mime  string
+}{
+	{"\xff\xd8\xff", "", "image/jpeg"},
+	{"\x89PNG\r\n\x1a\n", "", "image/png"},
+	{"GIF8", "", "image/gif"},
+	{"BM", "", "image/bmp"},
+	{"RIFF????WEBP", "....????....", "image/webp"},
+	{"II*\x00????CR", "....????..", "image/x-canon-cr2"},
+	{"IIRO", "", "image/x-olympus-orf"},
+	{"IIU\x00", "", "image/x-panasonic-rw2"},
+	{"FUJIFILMCCD-RAW", "", "image/x-fuji-raf"},
+	{"II*\x00", "", "image/tiff"},
+	{"MM\x00*", "", "image/tiff"},
+	{"8BPS", "", "image/vnd.adobe.photoshop"},
+	{"????ftypheic", "????........", "image/heic"},
+	{"????ftypheix", "????........", "image/heic"},
+	{"????ftypmif1", "????........", "image/heif"},
+	{"????ftypavif", "????........", "image/avif"},
+	{"\x00\x00\x00\x0cjP  \r\n\x87\n", "", "image/jp2"},
+	{"\x00\x00\x00\x0cJXL \r\n\x87\n", "", "image/jxl"},
+	{"\xff\x0a", "", "image/jxl"},
+	{"\x00\x00\x01\x00", "", "image/x-icon"},
+	{"\x00\x00\x02\x00", "", "image/x-win-bitmap"},
+	{"qoif", "", "image/qoi"},
+	{"farbfeld", "", "image/x-farbfeld"},
+	{"\x01\xda", "", "image/x-sgi"},
+	{"/* XPM */", "", "image/x-xpixmap"},
+	{"#define", "", "image/x-xbitmap"},
+	{"\x76\x2f\x31\x01", "", "image/x-exr"},
+	{"#?RADIANCE", "", "image/vnd.radiance"},
+	{"%!PS", "", "application/postscript"},
+	{"%PDF", "", "application/pdf"},
+	{"P1", "", "image/x-portable-bitmap"},
+	{"P4", "", "image/x-portable-bitmap"},
+	{"P2", "", "image/x-portable-graymap"},
+	{"P5", "", "image/x-portable-graymap"},
+	{"P3", "", "image/x-portable-pixmap"},
+	{"P6", "", "image/x-portable-pixmap"},
+	{"P7", "", "image/x-portable-arbitrarymap"},
+}
+
+// builtin is decoder of package, it returns nil if data is in other format.
+type builtin struct {
+	decode func(ctx context.Context, data []byte, page int, opts *Options) (image.Image, error)
+	// magick is set for decoders used ahead of ImageMagick
+	magick bool
+}
+
+// builtins are decoders of package in order they are tried, after external decoders.
+// Animations are decoded with Go decoders with ImageMagick too, so they can be played.
+var builtins = []builtin{
+	{decodeAnimationPage, true},
+	// RAW is TIFF too, sensor data and thumbnails are not pages
+	{decodeRAWPreview, false},
+	{decodePage, false},
+	{decodePSDLayers, false},
+	{decodeSVGVector, false},
+	{decodeCMYKImage, false},
+	{decodeImage, false},
+}
+
+// decodeAnimationPage decodes animation, other pages are not animated.
+func decodeAnimationPage(ctx context.Context, data []byte, page int, opts *Options) (image.Image, error) {
+	if page > 1 {
+		return nil, nil
+	}
+
+	a, err := decodeAnimation(ctx, data)
+	if err != nil || a == nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// decodeRAWPreview decodes preview of camera RAW.
+func decodeRAWPreview(ctx context.Context, data []byte, page int, opts *Options) (image.Image, error) {
+	return decodeRAW(ctx, data, opts.Exif)
+}
+
+// decodePage decodes page of multi-page TIFF or ICO, it returns error for missing page of other formats.
+func decodePage(ctx context.Context, data []byte, page int, opts *Options) (image.Image, error) {
+	p, err := decodeTIFFPage(data, page, opts.Exif)
+	if err == nil && p == nil {
+		p, err = decodeICOPage(data, page)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if p != nil {
+		return p, nil
+	}
+
+	if page > 1 {
+		return nil, fmt.Errorf("page %d not found", page)
+	}
+
+	return nil, nil
+}
+
+// decodePSDLayers decodes PSD with layers.
+func decodePSDLayers(ctx context.Context, data []byte, page int, opts *Options) (image.Image, error) {
+	return decodePSD(ctx, data)
+}
+
+// decodeSVGVector decodes SVG, it is rasterized when drawn.
+func decodeSVGVector(ctx context.Context, data []byte, page int, opts *Options) (image.Image, error) {
+	v, err := decodeSVG(data)
+	if err != nil || v == nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// decodeCMYKImage decodes CMYK JPEG with orientation and embedded profile.
+func decodeCMYKImage(ctx context.Context, data []byte, page int, opts *Options) (image.Image, error) {
+	c, err := decodeCMYK(ctx, data)
+	if err != nil || c == nil {
+		return nil, err
+	}
+
+	return orient(c, data, opts), nil
+}
+
+// decodeImage decodes data with registered image formats, with orientation and embedded profile.
+func decodeImage(ctx context.Context, data []byte, page int, opts *Options) (image.Image, error) {
+	img, _, err := image.Decode(newReader(ctx, bytes.NewReader(data), int64(len(data)), nil))
+	if err != nil {
+		return nil, err
+	}
+
+	return orient(img, data, opts), nil
+}
+
+// orient applies EXIF orientation and embedded profile of data to image.
+func orient(img image.Image, data []byte, opts *Options) image.Image {
+	if opts.Exif {
+		img = transform(img, exifOrientation(data))
+	}
+
+	return withProfile(img, data)
+}
+
+// imageExtensions are extensions of formats with decoder, and of formats usually converted by external decoders or ImageMagick.
+var imageExtensions = []string{
+	".jpg", ".jpeg", ".jpe", ".jfif", ".png", ".apng", ".gif", ".bmp", ".pcx", ".tif", ".tiff",
+	".pbm", ".pgm", ".ppm", ".pnm", ".pam", ".webp", ".psd", ".tga", ".qoi", ".ff", ".ico", ".cur",
+	".xbm", ".xpm", ".sgi", ".rgb", ".rgba", ".bw", ".hdr", ".pic", ".exr", ".svg",
+	".cr2", ".nef", ".arw", ".dng", ".pef", ".rw2", ".heic", ".heif", ".avif", ".jxl", ".jp2",
+}
+
+// maxPixels is maximal number of pixels of decoded image, larger sizes in headers are rejected before allocation.
+const maxPixels = 400000000
+
+// validSize checks if image with size and bytes per pixel is not larger than maxPixels and its buffer size fits in int.
+func validSize(width, height, bpp int) bool {
+	if width <= 0 || height <= 0 || width > maxPixels/height {
+		return false
+	}
+
+	return width*height <= int(^uint(0)>>1)/bpp
+}
+
+// hasImageExt checks if name has extension of image format.
+func hasImageExt(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range imageExtensions {
+		if ext == e {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sniff returns MIME type of data, detected from magic bytes or file extension.
+func sniff(filename string, data []byte) string {
+	for _, t := range mimeTypes {
+		if matchMagic(t.magic, t.mask, data) {
+			return t.mime
+		}
+	}
+
+	head := data
+	if len(head) > 512 {
+		head = head[:512]
+	}
+
+	if bytes.Contains(head, []byte("<svg")) {
+		return "image/svg+xml"
+	}
+
+	if t := mime.TypeByExtension(strings.ToLower(filepath.Ext(filename))); t != "" {
+		if i := strings.Index(t, ";"); i >= 0 {
+			t = t[:i]
+		}
+		return t
+	}
+
+	return ""
+}
+
+// matchMagic checks if data starts with magic, bytes marked with ? in mask match any byte.
+func matchMagic(magic, mask string, data []byte) bool {
+	if len(data) < len(magic) {
+		return false
+	}
+
+	for i := 0; i < len(magic); i++ {
+		if (i >= len(mask) || mask[i] != '?') && magic[i] != data[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// pnmHeader matches header of PNM and PAM images up to size, or first header line of PAM.
+var pnmHeader = regexp.MustCompile(`^(P[1-6]\s(\s|#[^\n]*\n)*\d+\s(\s|#[^\n]*\n)*\d+\s|P7\n(#[^\n]*\n)*(WIDTH|HEIGHT|DEPTH|MAXVAL|TUPLTYPE)\s)`)
+
+// validHeader checks header of formats with two magic bytes, text can start with them too.
+func validHeader(mimeType string, data []byte) bool {
+	switch mimeType {
+	case "image/x-portable-bitmap", "image/x-portable-graymap", "image/x-portable-pixmap", "image/x-portable-arbitrarymap":
+		return pnmHeader.Match(data)
+	case "image/bmp":
+		// size of DIB header
+		if len(data) < 18 {
+			return false
+		}
+
+		switch binary.LittleEndian.Uint32(data[14:]) {
+		case 12, 16, 40, 52, 56, 64, 108, 124:
+			return true
+		}
+
+		return false
+	case "image/x-sgi":
+		// storage and bytes per channel
+		return len(data) >= 4 && data[2] <= 1 && (data[3] == 1 || data[3] == 2)
+	}
+
+	return true
+}
+
+// errUnknownFormat returns error for data without decoder, with its MIME type and magic bytes.
+func errUnknownFormat(filename string, data []byte) error {
+	magic := data
+	if len(magic) > 8 {
+		magic = magic[:8]
+	}
+
+	if t := sniff(filename, data); t != "" {
+		return fmt.Errorf("no decoder for %s (%s, magic: % x)", filename, t, magic)
+	}
+
+	return fmt.Errorf("no decoder for %s (magic: % x)", filename, magic)
+}
+
+// parseDecoder parses decoder, name is MIME type or magic bytes in hex with 0x prefix, ?? matches any byte.
+func parseDecoder(name, command string) (Decoder, error) {
+	d := Decoder{}
+
+	d.Command = strings.Fields(command)
+	if len(d.Command) == 0 {
+		return d, fmt.Errorf("empty command for %s", name)
+	}
+
+	if !strings.HasPrefix(name, "0x") {
+		if !strings.Contains(name, "/") {
+			return d, fmt.Errorf("invalid MIME type %s", name)
+		}
+
+		d.MIME = name
+		return d, nil
+	}
+
+	digits := name[2:]
+	if len(digits) == 0 || len(digits)%2 != 0 {
+		return d, fmt.Errorf("invalid magic %s", name)
+	}
+
+	magic := make([]byte, len(digits)/2)
+	mask := make([]byte, len(digits)/2)
+
+	for i := range magic {
+		pair := digits[2*i : 2*i+2]
+		if pair == "??" {
+			mask[i] = '?'
+			continue
+		}
+
+		b, err := hex.DecodeString(pair)
+		if err != nil {
+			return d, fmt.Errorf("invalid magic %s", name)
+		}
+
+		magic[i] = b[0]
+		mask[i] = '.'
+	}
+
+	d.Magic = string(magic)
+	d.Mask = string(mask)
+
+	return d, nil
+}
+
+// match checks if decoder matches data with MIME type.
+func (d *Decoder) match(mimeType string, data []byte) bool {
+	if d.Magic != "" {
+		return matchMagic(d.Magic, d.Mask, data)
+	}
+
+	return d.MIME == mimeType
+}
+
+// decodeExternal decodes data with first matching decoder, it returns nil if no decoder matches.
+func decodeExternal(ctx context.Context, decoders []Decoder, filename string, data []byte) (image.Image, error) {
+	if len(decoders) == 0 {
+		return nil, nil
+	}
+
+	mimeType := sniff(filename, data)
+
+	for i := range decoders {
+		if decoders[i].match(mimeType, data) {
+			return decoders[i].decode(ctx, filename, data)
+		}
+	}
+
+	return nil, nil
+}
+
+// decode runs command and decodes its output.
+func (d *Decoder) decode(ctx context.Context, filename string, data []byte) (image.Image, error) {
+	args := make([]string, len(d.Command))
+	copy(args, d.Command)
+
+	var stdin io.Reader = bytes.NewReader(data)
+	output := ""
+
+	for i, arg := range args {
+		switch arg {
+		case "%f":
+			// extension is kept, some converters detect format from it
+			f, err := tempFile(data, filepath.Ext(filename))
+			if err != nil {
+				return nil, err
+			}
+
+			defer os.Remove(f)
+
+			args[i] = f
+			stdin = nil
+		case "%o":
+			f, err := tempFile(nil, ".png")
+			if err != nil {
+				return nil, err
+			}
+
+			defer os.Remove(f)
+
+			args[i] = f
+			output = f
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %s: %s", args[0], err.Error(), msg)
+		}
+		return nil, fmt.Errorf("%s: %s", args[0], err.Error())
+	}
+
+	out := stdout.Bytes()
+	if output != "" {
+		out, err = ioutil.ReadFile(output)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", args[0], err.Error())
+		}
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(out))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", args[0], err.Error())
+	}
+
+	return img, nil
+}
+
+// tempFile writes data to temporary file with extension and returns its name.
+func tempFile(data []byte, ext string) (string, error) {
+	file, err := ioutil.TempFile("", appName+"*"+ext)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = file.Write(data)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	err = file.Close()
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	return file.Name(), nil
+}
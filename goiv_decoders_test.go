@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseDecoderMagic(t *testing.T) {
+	tests := []struct {
+		magic string
+		data  string
+		match bool
+	}{
+		{"0x52494646????????57454250", "RIFF\x10\x00\x00\x00WEBP", true},
+		{"0x52494646????????57454250", "RIFF????WEBX", false},
+		{"0x3f3f", "??", true},
+		{"0x3f3f", "ab", false},
+		{"0x??ff", "\x00\xff", true},
+		{"0x??ff", "\x00\xfe", false},
+	}
+
+	for _, tt := range tests {
+		d, err := parseDecoder(tt.magic, "convert - png:-")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := d.match("", []byte(tt.data)); got != tt.match {
+			t.Errorf("%s, %q: got %v, want %v", tt.magic, tt.data, got, tt.match)
+		}
+	}
+
+	for _, magic := range []string{"0x", "0x5", "0x?f", "0xzz"} {
+		if _, err := parseDecoder(magic, "convert - png:-"); err == nil {
+			t.Errorf("%s: invalid magic accepted", magic)
+		}
+	}
+}
+
+func TestStdinImage(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		image bool
+	}{
+		{"list", "a.jpg\nb.jpg\n", false},
+		{"list starting with BM", "BMW.jpg\nAudi.jpg\n", false},
+		{"list starting with P1", "P1000123.JPG\nP1000124.JPG\n", false},
+		{"list starting with P7", "P7\nP8.jpg\n", false},
+		{"pbm", "P1\n2 2\n0 1\n1 0\n", true},
+		{"pgm with comment", "P5\n# comment\n2 2\n255\n\x00\x01\x02\x03", true},
+		{"pam", "P7\nWIDTH 1\nHEIGHT 1\nDEPTH 3\nMAXVAL 255\nENDHDR\n\x00\x00\x00", true},
+		{"bmp", "BM\x3a\x00\x00\x00\x00\x00\x00\x00\x36\x00\x00\x00\x28\x00\x00\x00", true},
+		{"sgi", "\x01\xda\x00\x01\x00\x02", true},
+		{"sgi header invalid", "\x01\xda\x05\x07", false},
+		{"png", "\x89PNG\r\n\x1a\n", true},
+	}
+
+	for _, tt := range tests {
+		if got := stdinImage([]byte(tt.data)); got != tt.image {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.image)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Scan selects and sorts images in directories.
+type Scan struct {
+	Recursive bool
+	Include   globs
+	Exclude   globs
+	Magic     bool // images are detected by magic bytes instead of extension
+	Sort      string
+}
+
+// sortOrders are names of sort orders of images in directories.
+var sortOrders = []string{"name", "natural", "mtime", "size", "random"}
+
+// validSort checks if name is sort order.
+func validSort(name string) bool {
+	for _, o := range sortOrders {
+		if o == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// globs is list of glob patterns, flag can be repeated.
+type globs []string
+
+// String implements flag.Value.
+func (g *globs) String() string {
+	return strings.Join(*g, ",")
+}
+
+// Set implements flag.Value.
+func (g *globs) Set(pattern string) error {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %s", pattern)
+	}
+
+	*g = append(*g, pattern)
+	return nil
+}
+
+// match checks if name or path relative to scanned directory matches any pattern, patterns with / match the path.
+func (g globs) match(rel string) bool {
+	rel = filepath.ToSlash(rel)
+
+	for _, pattern := range g {
+		s := rel
+		if !strings.Contains(pattern, "/") {
+			s = s[strings.LastIndex(s, "/")+1:]
+		}
+
+		if ok, _ := filepath.Match(pattern, s); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dirEntry is file found in directory.
+type dirEntry struct {
+	path string
+	rel  string
+	info os.FileInfo
+}
+
+// directory returns images in directory and, with recursive scan, in its subdirectories, hidden files are skipped.
+func (s *Scan) directory(dir string) ([]string, error) {
+	entries := make([]dirEntry, 0)
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// unreadable subdirectory is skipped
+			if path != dir {
+				return nil
+			}
+			return err
+		}
+
+		if path == dir {
+			return nil
+		}
+
+		if strings.HasPrefix(info.Name(), ".") || (info.IsDir() && !s.Recursive) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, _ := filepath.Rel(dir, path)
+		if info.Mode().IsRegular() && s.match(path, rel) {
+			entries = append(entries, dirEntry{path, rel, info})
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.sort(entries)
+
+	images := make([]string, len(entries))
+	for i, e := range entries {
+		images[i] = e.path
+	}
+
+	return images, nil
+}
+
+// match checks if file is image selected with include and exclude patterns.
+func (s *Scan) match(path, rel string) bool {
+	if len(s.Include) > 0 && !s.Include.match(rel) {
+		return false
+	}
+
+	if s.Exclude.match(rel) {
+		return false
+	}
+
+	if s.Magic {
+		return isImageFile(path)
+	}
+
+	return hasImageExt(rel)
+}
+
+// sort sorts entries in sort order, ties are sorted by name.
+func (s *Scan) sort(entries []dirEntry) {
+	switch s.Sort {
+	case "random":
+		rand.Shuffle(len(entries), func(i, j int) {
+			entries[i], entries[j] = entries[j], entries[i]
+		})
+	case "natural":
+		sort.SliceStable(entries, func(i, j int) bool {
+			return naturalLess(entries[i].rel, entries[j].rel)
+		})
+	case "mtime":
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].info.ModTime().Before(entries[j].info.ModTime())
+		})
+	case "size":
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].info.Size() < entries[j].info.Size()
+		})
+	}
+}
+
+// siblings returns images in directory of file and index of the file, or only the file if it is not selected.
+func (s *Scan) siblings(file string) ([]string, int) {
+	d := *s
+	d.Recursive = false
+
+	images, err := d.directory(filepath.Dir(file))
+	if err != nil {
+		return []string{file}, 0
+	}
+
+	for i, img := range images {
+		if img == filepath.Clean(file) {
+			return images, i
+		}
+	}
+
+	return []string{file}, 0
+}
+
+// isImageFile checks if file starts with magic bytes of image format.
+func isImageFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return false
+	}
+
+	t := sniff("", head[:n])
+
+	return strings.HasPrefix(t, "image/") && validHeader(t, head[:n])
+}
+
+// naturalLess compares names with numbers by value, i.e. img2.jpg is before img10.jpg.
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		da, db := digits(a), digits(b)
+
+		if da > 0 && db > 0 {
+			na, nb := strings.TrimLeft(a[:da], "0"), strings.TrimLeft(b[:db], "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+
+			if na != nb {
+				return na < nb
+			}
+
+			a, b = a[da:], b[db:]
+			continue
+		}
+
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+
+		a, b = a[1:], b[1:]
+	}
+
+	return len(a) < len(b)
+}
+
+// digits returns number of leading digits of s.
+func digits(s string) int {
+	n := 0
+	for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+		n++
+	}
+
+	return n
+}
@@ -3,15 +3,15 @@
 package main
 
 import (
-	"bytes"
 	"fmt"
+	"image"
+	"image/draw"
 	"os"
 	"unsafe"
 
 	"github.com/NeowayLabs/drm"
 	"github.com/NeowayLabs/drm/mode"
 	"github.com/edsrzf/mmap-go"
-	"github.com/pkg/term"
 )
 
 type frameBuffer struct {
@@ -30,7 +30,7 @@ type msetData struct {
 }
 
 // displayDRM displays images on DRM.
-func displayDRM(images []string) error {
+func displayDRM(images []string, opts *Options) error {
 	file, err := drm.OpenCard(0)
 	if err != nil {
 		return err
@@ -74,107 +74,66 @@ func displayDRM(images []string) error {
 		})
 	}
 
-	t, err := term.Open("/dev/tty")
+	t, err := openTTY()
 	if err != nil {
-		return fmt.Errorf("Open: %s", err.Error())
+		cleanup(modeset, msets, file)
+		return err
 	}
 
-	err = t.SetRaw()
+	defer t.Close()
+
+	d := &drmDisplay{tty: t, msets: msets}
+	v := NewViewer(images, opts, d)
+
+	err = v.Update()
 	if err != nil {
-		return fmt.Errorf("SetRaw: %s", err.Error())
+		cleanup(modeset, msets, file)
+		return err
 	}
 
-	defer t.Restore()
-	defer t.Close()
-
-	idx := 0
+	t.wait(v)
 
-	update := func() error {
-		var off uint32
+	cleanup(modeset, msets, file)
 
-		img, err := decode(images[idx], int(msets[0].fb.fb.Width), int(msets[0].fb.fb.Height))
-		if err != nil {
-			return err
-		}
+	return nil
+}
 
-		bounds := img.Bounds()
-
-		for j := 0; j < len(msets); j++ {
-			mset := msets[j]
-			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-				for x := bounds.Min.X; x < bounds.Max.X; x++ {
-					r, g, b, _ := img.At(x, y).RGBA()
-					off = mset.fb.stride*uint32(y) + uint32(x)*4
-					val := uint32((uint32(r) << 16) | (uint32(g) << 8) | uint32(b))
-					*(*uint32)(unsafe.Pointer(&mset.fb.data[off])) = val
-				}
-			}
-		}
+// drmDisplay draws on DRM framebuffers.
+type drmDisplay struct {
+	*tty
+	msets []msetData
+}
 
-		return nil
-	}
+// Size returns size of the first framebuffer.
+func (d *drmDisplay) Size() (int, int) {
+	return int(d.msets[0].fb.fb.Width), int(d.msets[0].fb.fb.Height)
+}
 
-	char := func() ([]byte, error) {
-		bytes := make([]byte, 3)
-		n, err := t.Read(bytes)
-		if err != nil {
-			return nil, err
-		}
+// Draw copies image to all framebuffers.
+func (d *drmDisplay) Draw(img image.Image, title string) error {
+	bounds := img.Bounds()
 
-		return bytes[0:n], nil
+	// frames are 8-bit, images with more bits per channel are reduced, and dithered, when rendered
+	src, ok := img.(*image.RGBA)
+	if !ok {
+		src = image.NewRGBA(bounds)
+		draw.Draw(src, bounds, img, bounds.Min, draw.Src)
 	}
 
-	wait := func() {
-		for {
-			c, err := char()
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "%s\n", err.Error())
-			}
+	for _, mset := range d.msets {
+		width := int(mset.fb.fb.Width)
+		height := int(mset.fb.fb.Height)
 
-			switch {
-			case bytes.Equal(c, []byte{3}), bytes.Equal(c, []byte{113}), bytes.Equal(c, []byte{27}): // ctrl+c, q, Esc
-				return
-			case bytes.Equal(c, []byte{27, 91, 68}), bytes.Equal(c, []byte{27, 91, 53}), bytes.Equal(c, []byte{107}): // Left, Page_Up, k
-				if idx != 0 {
-					idx -= 1
-					update()
-				}
-			case bytes.Equal(c, []byte{27, 91, 67}), bytes.Equal(c, []byte{27, 91, 54}), bytes.Equal(c, []byte{106}), bytes.Equal(c, []byte{32}): // Right, Page_Down, j, Space
-				if idx != len(images)-1 {
-					idx += 1
-					update()
-				}
-			case bytes.Equal(c, []byte{91}): // [
-				if idx+10 <= len(images)-1 {
-					idx += 10
-					update()
-				}
-			case bytes.Equal(c, []byte{93}): // ]
-				if idx+10 <= len(images)-1 {
-					idx += 10
-					update()
-				}
-			case bytes.Equal(c, []byte{44}): // ,
-				idx = 0
-				update()
-			case bytes.Equal(c, []byte{46}): // .
-				idx = len(images) - 1
-				update()
-			case bytes.Equal(c, []byte{13}): // Return
-				fmt.Fprintf(os.Stdout, "%s\n", images[idx])
+		for y := bounds.Min.Y; y < bounds.Max.Y && y < height; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X && x < width; x++ {
+				p := src.Pix[src.PixOffset(x, y):]
+				off := mset.fb.stride*uint32(y) + uint32(x)*4
+				val := uint32(p[0])<<16 | uint32(p[1])<<8 | uint32(p[2])
+				*(*uint32)(unsafe.Pointer(&mset.fb.data[off])) = val
 			}
 		}
 	}
 
-	err = update()
-	if err != nil {
-		return err
-	}
-
-	wait()
-
-	cleanup(modeset, msets, file)
-
 	return nil
 }
 
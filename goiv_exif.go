@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// exifOrientations maps EXIF orientation tag values to orientations.
+var exifOrientations = map[uint16]Orientation{
+	1: {false, 0},
+	2: {true, 0},
+	3: {false, 2},
+	4: {true, 2},
+	5: {true, 3},
+	6: {false, 1},
+	7: {true, 1},
+	8: {false, 3},
+}
+
+// exifOrientation returns orientation from EXIF data in JPEG APP1 segment or TIFF IFD0.
+func exifOrientation(data []byte) Orientation {
+	if tiff := exifTIFF(data); tiff != nil {
+		if v, ok := tiffTag(tiff, 0x0112); ok {
+			return exifOrientations[uint16(v)]
+		}
+	}
+
+	return Orientation{}
+}
+
+// exifTIFF returns TIFF structure embedded in JPEG APP1 segment, or data itself if it is TIFF.
+func exifTIFF(data []byte) []byte {
+	if bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*")) {
+		return data
+	}
+
+	for _, segment := range jpegSegments(data, 0xe1) {
+		if bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:]
+		}
+	}
+
+	return nil
+}
+
+// jpegSegments returns payloads of JPEG segments with marker, metadata segments are before start of scan.
+func jpegSegments(data []byte, marker byte) [][]byte {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil
+	}
+
+	segments := make([][]byte, 0)
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			break
+		}
+
+		m := data[i+1]
+		if m == 0xd8 || m == 0x01 || (m >= 0xd0 && m <= 0xd7) {
+			i += 2
+			continue
+		}
+
+		// start of scan or end of image, no more metadata
+		if m == 0xda || m == 0xd9 {
+			break
+		}
+
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if size < 2 || i+2+size > len(data) {
+			break
+		}
+
+		if m == marker {
+			segments = append(segments, data[i+4:i+2+size])
+		}
+
+		i += 2 + size
+	}
+
+	return segments
+}
+
+// tiffOrder returns byte order of TIFF structure.
+func tiffOrder(tiff []byte) binary.ByteOrder {
+	if len(tiff) < 8 {
+		return nil
+	}
+
+	switch string(tiff[:2]) {
+	case "II":
+		return binary.LittleEndian
+	case "MM":
+		return binary.BigEndian
+	}
+
+	return nil
+}
+
+// tiffTag returns value of short or long tag in IFD0.
+func tiffTag(tiff []byte, tag uint16) (uint32, bool) {
+	order := tiffOrder(tiff)
+	if order == nil {
+		return 0, false
+	}
+
+	return ifdTag(tiff, order, int(order.Uint32(tiff[4:])), tag)
+}
+
+// ifdTag returns value of short or long tag in IFD at offset.
+func ifdTag(tiff []byte, order binary.ByteOrder, offset int, tag uint16) (uint32, bool) {
+	e := ifdEntry(tiff, order, offset, tag)
+	if e == nil {
+		return 0, false
+	}
+
+	switch order.Uint16(e[2:]) {
+	case 3: // short
+		return uint32(order.Uint16(e[8:])), true
+	case 4: // long
+		return order.Uint32(e[8:]), true
+	}
+
+	return 0, false
+}
+
+// ifdBytes returns value of byte or undefined tag in IFD at offset.
+func ifdBytes(tiff []byte, order binary.ByteOrder, offset int, tag uint16) []byte {
+	e := ifdEntry(tiff, order, offset, tag)
+	if e == nil || (order.Uint16(e[2:]) != 1 && order.Uint16(e[2:]) != 7) {
+		return nil
+	}
+
+	// values up to 4 bytes are stored in entry
+	n := int(order.Uint32(e[4:]))
+	if n <= 4 {
+		return e[8 : 8+n]
+	}
+
+	off := int(order.Uint32(e[8:]))
+	if off < 8 || n > len(tiff) || off > len(tiff)-n {
+		return nil
+	}
+
+	return tiff[off : off+n]
+}
+
+// ifdLongs returns values of long or IFD tag in IFD at offset, i.e. offsets of SubIFDs.
+func ifdLongs(tiff []byte, order binary.ByteOrder, offset int, tag uint16) []int {
+	e := ifdEntry(tiff, order, offset, tag)
+	if e == nil || (order.Uint16(e[2:]) != 4 && order.Uint16(e[2:]) != 13) {
+		return nil
+	}
+
+	// one value is stored in entry
+	n := int(order.Uint32(e[4:]))
+	if n == 1 {
+		return []int{int(order.Uint32(e[8:]))}
+	}
+
+	off := int(order.Uint32(e[8:]))
+	if off < 8 || n > len(tiff)/4 || off > len(tiff)-n*4 {
+		return nil
+	}
+
+	values := make([]int, n)
+	for i := range values {
+		values[i] = int(order.Uint32(tiff[off+i*4:]))
+	}
+
+	return values
+}
+
+// ifdEntry returns 12 bytes entry of tag in IFD at offset.
+func ifdEntry(tiff []byte, order binary.ByteOrder, offset int, tag uint16) []byte {
+	if offset < 8 || offset+2 > len(tiff) {
+		return nil
+	}
+
+	n := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < n; i++ {
+		e := offset + 2 + i*12
+		if e+12 > len(tiff) {
+			return nil
+		}
+
+		if order.Uint16(tiff[e:]) == tag {
+			return tiff[e : e+12]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strings"
+)
+
+// exrMagic is magic number of OpenEXR file.
+const exrMagic = "\x76\x2f\x31\x01"
+
+func init() {
+	image.RegisterFormat("exr", exrMagic, decodeEXR, decodeEXRConfig)
+}
+
+// OpenEXR compression methods.
+const (
+	exrNone = iota
+	exrRLE
+	exrZIPS
+	exrZIP
+	exrPIZ
+)
+
+// exrCompressions are names of compression methods, for errors.
+var exrCompressions = []string{"none", "rle", "zips", "zip", "piz", "pxr24", "b44", "b44a", "dwaa", "dwab"}
+
+// exrChannel is channel of OpenEXR image, pixel type is 0 for uint, 1 for half and 2 for float.
+type exrChannel struct {
+	name      string
+	pixelType int
+	xSampling int
+	ySampling int
+}
+
+// size returns size of sample in bytes.
+func (c exrChannel) size() int {
+	if c.pixelType == 1 {
+		return 2
+	}
+	return 4
+}
+
+// exrHeader is header of single part scanline OpenEXR image.
+type exrHeader struct {
+	channels    []exrChannel
+	compression int
+	data        image.Rectangle
+	display     image.Rectangle
+}
+
+// readEXRHeader reads header, it returns header and data following it.
+func readEXRHeader(data []byte) (*exrHeader, []byte, error) {
+	if len(data) < 8 || string(data[:4]) != exrMagic {
+		return nil, nil, fmt.Errorf("exr: invalid format")
+	}
+
+	le := binary.LittleEndian
+
+	flags := le.Uint32(data[4:])
+	if flags&0x200 != 0 {
+		return nil, nil, fmt.Errorf("exr: tiled images are not supported")
+	}
+	if flags&0x1800 != 0 {
+		return nil, nil, fmt.Errorf("exr: multi-part and deep images are not supported")
+	}
+
+	h := &exrHeader{compression: -1}
+	data = data[8:]
+
+	str := func() (string, error) {
+		i := bytes.IndexByte(data, 0)
+		if i < 0 {
+			return "", fmt.Errorf("exr: invalid header")
+		}
+
+		s := string(data[:i])
+		data = data[i+1:]
+
+		return s, nil
+	}
+
+	box := func(v []byte) image.Rectangle {
+		return image.Rect(int(int32(le.Uint32(v))), int(int32(le.Uint32(v[4:]))), int(int32(le.Uint32(v[8:])))+1, int(int32(le.Uint32(v[12:])))+1)
+	}
+
+	for {
+		name, err := str()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if name == "" {
+			break
+		}
+
+		typ, err := str()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(data) < 4 {
+			return nil, nil, fmt.Errorf("exr: invalid header")
+		}
+
+		size := int(le.Uint32(data))
+		if size < 0 || 4+size > len(data) {
+			return nil, nil, fmt.Errorf("exr: invalid attribute %s", name)
+		}
+
+		value := data[4 : 4+size]
+		data = data[4+size:]
+
+		switch {
+		case name == "channels" && typ == "chlist":
+			for len(value) > 0 && value[0] != 0 {
+				i := bytes.IndexByte(value, 0)
+				if i < 0 || len(value) < i+17 {
+					return nil, nil, fmt.Errorf("exr: invalid channels")
+				}
+
+				v := value[i+1:]
+				c := exrChannel{
+					name:      string(value[:i]),
+					pixelType: int(le.Uint32(v)),
+					xSampling: int(int32(le.Uint32(v[8:]))),
+					ySampling: int(int32(le.Uint32(v[12:]))),
+				}
+
+				if c.pixelType > 2 {
+					return nil, nil, fmt.Errorf("exr: invalid pixel type of channel %s", c.name)
+				}
+
+				if c.xSampling != 1 || c.ySampling != 1 {
+					return nil, nil, fmt.Errorf("exr: subsampled channels are not supported")
+				}
+
+				h.channels = append(h.channels, c)
+				value = v[16:]
+			}
+		case name == "compression" && size == 1:
+			h.compression = int(value[0])
+		case name == "dataWindow" && size == 16:
+			h.data = box(value)
+		case name == "displayWindow" && size == 16:
+			h.display = box(value)
+		}
+	}
+
+	w := h.window()
+	if len(h.channels) == 0 || h.data.Empty() || w.Dx() > 1<<16 || w.Dy() > 1<<16 || h.data.Dx() > 1<<16 || h.data.Dy() > 1<<16 {
+		return nil, nil, fmt.Errorf("exr: invalid header")
+	}
+
+	// image is allocated with 16 bytes per pixel
+	if !validSize(w.Dx(), w.Dy(), 16) || !validSize(h.data.Dx(), h.data.Dy(), 16) {
+		return nil, nil, fmt.Errorf("exr: invalid size %dx%d", w.Dx(), w.Dy())
+	}
+
+	if h.compression < 0 || h.compression >= len(exrCompressions) {
+		return nil, nil, fmt.Errorf("exr: invalid compression")
+	}
+
+	if h.compression > exrPIZ {
+		return nil, nil, fmt.Errorf("exr: %s compression is not supported", exrCompressions[h.compression])
+	}
+
+	// channels are stored sorted by name
+	sort.SliceStable(h.channels, func(i, j int) bool {
+		return h.channels[i].name < h.channels[j].name
+	})
+
+	return h, data, nil
+}
+
+// window returns display window, or data window when it is outside of display window.
+func (h *exrHeader) window() image.Rectangle {
+	if h.display.Empty() || !h.data.Overlaps(h.display) {
+		return h.data
+	}
+
+	return h.display
+}
+
+// lines returns number of scanlines in chunk.
+func (h *exrHeader) lines() int {
+	switch h.compression {
+	case exrZIP:
+		return 16
+	case exrPIZ:
+		return 32
+	}
+
+	return 1
+}
+
+// rgba returns indexes of channels used as red, green, blue and alpha, -1 for missing channels.
+// Channels of default layer are used, or of the first layer with color channels, i.e. diffuse.R.
+func (h *exrHeader) rgba() [4]int {
+	layer := ""
+	found := false
+
+	for _, c := range h.channels {
+		i := strings.LastIndex(c.name, ".")
+		switch c.name[i+1:] {
+		case "R", "G", "B", "Y":
+			if !found || i < 0 {
+				layer, found = c.name[:i+1], true
+			}
+		}
+	}
+
+	idx := [4]int{-1, -1, -1, -1}
+	for i, c := range h.channels {
+		switch c.name {
+		case layer + "R":
+			idx[0] = i
+		case layer + "G":
+			idx[1] = i
+		case layer + "B":
+			idx[2] = i
+		case layer + "A":
+			idx[3] = i
+		case layer + "Y":
+			if idx[0] < 0 && idx[1] < 0 && idx[2] < 0 {
+				idx[0], idx[1], idx[2] = i, i, i
+			}
+		}
+	}
+
+	// single unnamed channel is shown as gray
+	if idx[0] < 0 && idx[1] < 0 && idx[2] < 0 && len(h.channels) == 1 {
+		idx[0], idx[1], idx[2] = 0, 0, 0
+	}
+
+	return idx
+}
+
+// decodeEXRConfig returns color model and size of OpenEXR image.
+func decodeEXRConfig(r io.Reader) (image.Config, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	h, _, err := readEXRHeader(data)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	b := h.window()
+
+	return image.Config{ColorModel: color.NRGBA64Model, Width: b.Dx(), Height: b.Dy()}, nil
+}
+
+// decodeEXR decodes OpenEXR image.
+func decodeEXR(r io.Reader) (image.Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeEXRData(data)
+}
+
+// decodeEXRData decodes single part scanline OpenEXR image.
+func decodeEXRData(data []byte) (*HDR, error) {
+	h, rest, err := readEXRHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	le := binary.LittleEndian
+
+	width, height := h.data.Dx(), h.data.Dy()
+	lines := h.lines()
+	chunks := (height + lines - 1) / lines
+
+	if len(rest) < chunks*8 {
+		return nil, fmt.Errorf("exr: unexpected end of data")
+	}
+
+	// data window is placed in display window, image starts at origin
+	window := h.window()
+	img := NewHDR(window.Sub(window.Min))
+	idx := h.rgba()
+
+	// offset of channel in scanline and size of scanline
+	pos := make([]int, len(h.channels))
+	stride := 0
+	for i, c := range h.channels {
+		pos[i] = stride
+		stride += width * c.size()
+	}
+
+	for n := 0; n < chunks; n++ {
+		off := le.Uint64(rest[n*8:])
+		if off > uint64(len(data)) || uint64(len(data))-off < 8 {
+			return nil, fmt.Errorf("exr: invalid chunk offset")
+		}
+
+		chunk := data[off:]
+		y := int(int32(le.Uint32(chunk)))
+		size := int(le.Uint32(chunk[4:]))
+		if size < 0 || size > len(chunk)-8 || y < h.data.Min.Y || y >= h.data.Max.Y {
+			return nil, fmt.Errorf("exr: invalid chunk at line %d", y)
+		}
+
+		count := lines
+		if y+count > h.data.Max.Y {
+			count = h.data.Max.Y - y
+		}
+
+		raw := chunk[8 : 8+size]
+		if size < count*stride {
+			raw, err = h.uncompress(raw, width, count, count*stride)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if len(raw) < count*stride {
+			return nil, fmt.Errorf("exr: unexpected end of data at line %d", y)
+		}
+
+		for l := 0; l < count; l++ {
+			line := raw[l*stride:]
+			row := y + l - window.Min.Y
+
+			for x := 0; x < width; x++ {
+				col := h.data.Min.X + x - window.Min.X
+				if !(image.Point{col, row}.In(img.Rect)) {
+					continue
+				}
+
+				i := img.PixOffset(col, row)
+				img.Pix[i+3] = 1
+
+				for s, ci := range idx {
+					if ci < 0 {
+						continue
+					}
+
+					c := h.channels[ci]
+					v := line[pos[ci]+x*c.size():]
+
+					switch c.pixelType {
+					case 0:
+						img.Pix[i+s] = float32(le.Uint32(v))
+					case 1:
+						img.Pix[i+s] = half(le.Uint16(v))
+					case 2:
+						img.Pix[i+s] = math.Float32frombits(le.Uint32(v))
+					}
+				}
+			}
+		}
+	}
+
+	return img, nil
+}
+
+// uncompress uncompresses chunk of count scanlines to size bytes.
+func (h *exrHeader) uncompress(data []byte, width, count, size int) ([]byte, error) {
+	switch h.compression {
+	case exrRLE:
+		out, err := unRLE(data, size)
+		if err != nil {
+			return nil, err
+		}
+
+		return unpredict(out), nil
+	case exrZIPS, exrZIP:
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("exr: %s", err.Error())
+		}
+
+		out := make([]byte, size)
+		if _, err := io.ReadFull(zr, out); err != nil {
+			return nil, fmt.Errorf("exr: %s", err.Error())
+		}
+
+		return unpredict(out), nil
+	case exrPIZ:
+		return h.unPIZ(data, width, count, size)
+	}
+
+	return data, nil
+}
+
+// unRLE decodes run-length encoded data of size bytes.
+func unRLE(data []byte, size int) ([]byte, error) {
+	out := make([]byte, 0, size)
+
+	for len(data) > 0 {
+		n := int(int8(data[0]))
+
+		if n < 0 {
+			if len(data) < 1-n {
+				return nil, fmt.Errorf("exr: invalid rle data")
+			}
+
+			out = append(out, data[1:1-n]...)
+			data = data[1-n:]
+		} else {
+			if len(data) < 2 {
+				return nil, fmt.Errorf("exr: invalid rle data")
+			}
+
+			for i := 0; i <= n; i++ {
+				out = append(out, data[1])
+			}
+			data = data[2:]
+		}
+
+		if len(out) > size {
+			return nil, fmt.Errorf("exr: invalid rle data")
+		}
+	}
+
+	return out, nil
+}
+
+// unpredict reverts delta predictor and interleaves two halves of data, as done by zip and rle compression.
+func unpredict(data []byte) []byte {
+	for i := 1; i < len(data); i++ {
+		data[i] = data[i-1] + data[i] - 128
+	}
+
+	out := make([]byte, len(data))
+	half := (len(data) + 1) / 2
+
+	for i := range out {
+		if i%2 == 0 {
+			out[i] = data[i/2]
+		} else {
+			out[i] = data[half+i/2]
+		}
+	}
+
+	return out
+}
+
+// half converts 16-bit float to float32.
+func half(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1f
+	mant := uint32(h) & 0x3ff
+
+	switch {
+	case exp == 0 && mant == 0:
+		return math.Float32frombits(sign)
+	case exp == 0:
+		// subnormal
+		v := float32(mant) / (1 << 24)
+		if sign != 0 {
+			v = -v
+		}
+		return v
+	case exp == 0x1f:
+		return math.Float32frombits(sign | 0xff<<23 | mant<<13)
+	}
+
+	return math.Float32frombits(sign | (exp+127-15)<<23 | mant<<13)
+}
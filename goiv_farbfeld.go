@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+)
+
+func init() {
+	image.RegisterFormat("farbfeld", "farbfeld", decodeFarbfeld, decodeFarbfeldConfig)
+}
+
+// farbfeldHeader reads farbfeld header.
+func farbfeldHeader(r io.Reader) (int, int, error) {
+	var h [16]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return 0, 0, err
+	}
+
+	if string(h[:8]) != "farbfeld" {
+		return 0, 0, fmt.Errorf("farbfeld: invalid format")
+	}
+
+	width := int(binary.BigEndian.Uint32(h[8:]))
+	height := int(binary.BigEndian.Uint32(h[12:]))
+	if !validSize(width, height, 8) {
+		return 0, 0, fmt.Errorf("farbfeld: invalid size %dx%d", width, height)
+	}
+
+	return width, height, nil
+}
+
+// decodeFarbfeldConfig returns color model and size of farbfeld image.
+func decodeFarbfeldConfig(r io.Reader) (image.Config, error) {
+	width, height, err := farbfeldHeader(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	return image.Config{ColorModel: color.NRGBA64Model, Width: width, Height: height}, nil
+}
+
+// decodeFarbfeld decodes farbfeld image, pixels are 16-bit big endian RGBA without premultiplied alpha.
+func decodeFarbfeld(r io.Reader) (image.Image, error) {
+	width, height, err := farbfeldHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// buffer grows with data read, size in header is not trusted
+	size := int64(width) * int64(height) * 8
+
+	pix, err := ioutil.ReadAll(io.LimitReader(r, size))
+	if err != nil {
+		return nil, fmt.Errorf("farbfeld: %s", err.Error())
+	}
+
+	if int64(len(pix)) < size {
+		return nil, fmt.Errorf("farbfeld: %s", io.ErrUnexpectedEOF.Error())
+	}
+
+	// pixel layout is the same as of NRGBA64
+	return &image.NRGBA64{Pix: pix, Stride: width * 8, Rect: image.Rect(0, 0, width, height)}, nil
+}
@@ -3,18 +3,15 @@
 package main
 
 import (
-	"bytes"
 	"fmt"
 	"image"
 	"image/draw"
-	"os"
 
 	"github.com/gen2brain/framebuffer"
-	"github.com/pkg/term"
 )
 
 // displayFB displays images on Linux framebuffer.
-func displayFB(images []string) error {
+func displayFB(images []string, opts *Options) error {
 	canvas, err := framebuffer.Open(nil)
 	if err != nil {
 		return fmt.Errorf("Open: %s", err.Error())
@@ -32,99 +29,42 @@ func displayFB(images []string) error {
 		return fmt.Errorf("Image: %s", err.Error())
 	}
 
-	t, err := term.Open("/dev/tty")
+	t, err := openTTY()
 	if err != nil {
-		return fmt.Errorf("Open: %s", err.Error())
-	}
-
-	err = t.SetRaw()
-	if err != nil {
-		return fmt.Errorf("SetRaw: %s", err.Error())
+		return err
 	}
 
-	defer t.Restore()
 	defer t.Close()
 
-	idx := 0
-	var img image.Image
+	d := &fbDisplay{tty: t, fb: fb, width: mode.Geometry.XRes, height: mode.Geometry.YRes}
+	v := NewViewer(images, opts, d)
 
-	update := func() error {
-		img, err = decode(images[idx], mode.Geometry.XRes, mode.Geometry.YRes)
-		if err != nil {
-			return err
-		}
-
-		fbb := fb.Bounds()
-		imgb := img.Bounds()
-
-		imgb = imgb.Add(image.Point{
-			(fbb.Dx() / 2) - (imgb.Dx() / 2),
-			(fbb.Dy() / 2) - (imgb.Dy() / 2),
-		})
-
-		draw.Draw(fb, imgb, img, image.ZP, draw.Src)
-
-		return nil
+	err = v.Update()
+	if err != nil {
+		return err
 	}
 
-	char := func() ([]byte, error) {
-		bytes := make([]byte, 3)
-		n, err := t.Read(bytes)
-		if err != nil {
-			return nil, err
-		}
+	t.wait(v)
 
-		return bytes[0:n], nil
-	}
+	return nil
+}
 
-	wait := func() {
-		for {
-			c, err := char()
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "%s\n", err.Error())
-			}
-
-			switch {
-			case bytes.Equal(c, []byte{3}), bytes.Equal(c, []byte{113}), bytes.Equal(c, []byte{27}): // ctrl+c, q, Esc
-				return
-			case bytes.Equal(c, []byte{27, 91, 68}), bytes.Equal(c, []byte{27, 91, 53}), bytes.Equal(c, []byte{107}): // Left, Page_Up, k
-				if idx != 0 {
-					idx -= 1
-					update()
-				}
-			case bytes.Equal(c, []byte{27, 91, 67}), bytes.Equal(c, []byte{27, 91, 54}), bytes.Equal(c, []byte{106}), bytes.Equal(c, []byte{32}): // Right, Page_Down, j, Space
-				if idx != len(images)-1 {
-					idx += 1
-					update()
-				}
-			case bytes.Equal(c, []byte{91}): // [
-				if idx+10 <= len(images)-1 {
-					idx += 10
-					update()
-				}
-			case bytes.Equal(c, []byte{93}): // ]
-				if idx+10 <= len(images)-1 {
-					idx += 10
-					update()
-				}
-			case bytes.Equal(c, []byte{44}): // ,
-				idx = 0
-				update()
-			case bytes.Equal(c, []byte{46}): // .
-				idx = len(images) - 1
-				update()
-			case bytes.Equal(c, []byte{13}): // Return
-				fmt.Fprintf(os.Stdout, "%s\n", images[idx])
-			}
-		}
-	}
+// fbDisplay draws on Linux framebuffer.
+type fbDisplay struct {
+	*tty
+	fb     draw.Image
+	width  int
+	height int
+}
 
-	err = update()
-	if err != nil {
-		return err
-	}
+// Size returns framebuffer resolution.
+func (d *fbDisplay) Size() (int, int) {
+	return d.width, d.height
+}
 
-	wait()
+// Draw copies image to framebuffer.
+func (d *fbDisplay) Draw(img image.Image, title string) error {
+	draw.Draw(d.fb, d.fb.Bounds(), img, image.ZP, draw.Src)
 
 	return nil
 }
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sync"
+)
+
+// ToneMap is operator that maps HDR radiance to display range.
+type ToneMap int
+
+// Tone mapping operators.
+const (
+	ToneReinhard ToneMap = iota
+	ToneACES
+	ToneClip
+)
+
+// toneMapNames maps tone mapping operators to names.
+var toneMapNames = map[ToneMap]string{
+	ToneReinhard: "reinhard",
+	ToneACES:     "aces",
+	ToneClip:     "clip",
+}
+
+// parseToneMap returns tone mapping operator for name.
+func parseToneMap(name string) (ToneMap, error) {
+	for t, n := range toneMapNames {
+		if n == name {
+			return t, nil
+		}
+	}
+
+	return ToneReinhard, fmt.Errorf("unknown tone mapping %s", name)
+}
+
+// Exposure limits and step, in stops.
+const (
+	exposureMin  = -16
+	exposureMax  = 16
+	exposureStep = 0.5
+)
+
+// HDR is floating point image with linear, premultiplied RGBA samples, i.e. Radiance or OpenEXR.
+type HDR struct {
+	Pix    []float32
+	Stride int
+	Rect   image.Rectangle
+}
+
+// NewHDR returns new HDR image with bounds r.
+func NewHDR(r image.Rectangle) *HDR {
+	return &HDR{Pix: make([]float32, 4*r.Dx()*r.Dy()), Stride: 4 * r.Dx(), Rect: r}
+}
+
+// ColorModel implements image.Image.
+func (h *HDR) ColorModel() color.Model {
+	return color.NRGBA64Model
+}
+
+// Bounds implements image.Image.
+func (h *HDR) Bounds() image.Rectangle {
+	return h.Rect
+}
+
+// At implements image.Image, radiance is clipped to display range.
+func (h *HDR) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(h.Rect)) {
+		return color.NRGBA64{}
+	}
+
+	i := h.PixOffset(x, y)
+
+	return toneMap(h.Pix[i:i+4], 1, ToneClip)
+}
+
+// PixOffset returns index of the first sample of pixel at x, y.
+func (h *HDR) PixOffset(x, y int) int {
+	return (y-h.Rect.Min.Y)*h.Stride + (x-h.Rect.Min.X)*4
+}
+
+// ToneMap returns image mapped to display range with exposure in stops, encoded as sRGB.
+func (h *HDR) ToneMap(exposure float64, op ToneMap) *image.NRGBA64 {
+	img := image.NewNRGBA64(h.Rect)
+	k := math.Exp2(exposure)
+
+	for y := h.Rect.Min.Y; y < h.Rect.Max.Y; y++ {
+		for x := h.Rect.Min.X; x < h.Rect.Max.X; x++ {
+			i := h.PixOffset(x, y)
+			img.SetNRGBA64(x, y, toneMap(h.Pix[i:i+4], k, op))
+		}
+	}
+
+	return img
+}
+
+// toneMap maps premultiplied pixel scaled by k to sRGB color.
+func toneMap(px []float32, k float64, op ToneMap) color.NRGBA64 {
+	a := math.Max(0, math.Min(1, float64(px[3])))
+	if a == 0 {
+		return color.NRGBA64{}
+	}
+
+	var c [3]uint16
+	for i := range c {
+		v := float64(px[i]) / a * k
+
+		switch op {
+		case ToneReinhard:
+			v = v / (1 + v)
+		case ToneACES:
+			// curve fit by Krzysztof Narkowicz, input is scaled to match exposure of the reference
+			v *= 0.6
+			v = (v * (2.51*v + 0.03)) / (v*(2.43*v+0.59) + 0.14)
+		}
+
+		c[i] = srgb(v)
+	}
+
+	return color.NRGBA64{c[0], c[1], c[2], uint16(a*0xffff + 0.5)}
+}
+
+var (
+	srgbOnce  sync.Once
+	srgbTable []uint16
+)
+
+// srgb encodes linear value in range 0-1 as 16-bit sRGB, values outside of range are clipped.
+func srgb(v float64) uint16 {
+	srgbOnce.Do(func() {
+		srgbTable = make([]uint16, 1<<16)
+		for i := range srgbTable {
+			l := float64(i) / 0xffff
+
+			s := 12.92 * l
+			if l > 0.0031308 {
+				s = 1.055*math.Pow(l, 1/2.4) - 0.055
+			}
+
+			srgbTable[i] = uint16(s*0xffff + 0.5)
+		}
+	})
+
+	if !(v > 0) {
+		return 0
+	} else if v >= 1 {
+		return 0xffff
+	}
+
+	return srgbTable[int(v*0xffff+0.5)]
+}
+
+// decodeHDR decodes Radiance or OpenEXR image, it returns nil if data is in other format.
+func decodeHDR(data []byte) (*HDR, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte(exrMagic)):
+		return decodeEXRData(data)
+	case bytes.HasPrefix(data, []byte("#?RADIANCE")), bytes.HasPrefix(data, []byte("#?RGBE")):
+		return decodeRadianceData(data)
+	}
+
+	return nil, nil
+}
+
+// tone returns HDR image mapped to display range, other images are returned as they are.
+func tone(img image.Image, exposure float64, op ToneMap) image.Image {
+	if h, ok := img.(*HDR); ok {
+		return h.ToneMap(exposure, op)
+	}
+
+	return img
+}
+
+// Expose changes exposure of HDR images by stops, zero resets it.
+func (v *Viewer) Expose(stops float64) {
+	if stops == 0 {
+		v.exposure = v.opts.Exposure
+	} else {
+		v.exposure = math.Max(exposureMin, math.Min(exposureMax, v.exposure+stops))
+	}
+
+	v.retone()
+}
+
+// CycleToneMap selects next tone mapping operator of HDR images.
+func (v *Viewer) CycleToneMap() {
+	v.tonemap = (v.tonemap + 1) % (ToneClip + 1)
+
+	v.retone()
+}
+
+// retone redraws current image if it is HDR.
+func (v *Viewer) retone() {
+	if _, ok := v.orig.(*HDR); !ok {
+		v.status()
+		return
+	}
+
+	v.img = transform(v.source(), v.orientations[v.Current()])
+
+	v.redraw()
+}
+
+// hdrTitle returns exposure and tone mapping for title.
+func (v *Viewer) hdrTitle() string {
+	if _, ok := v.orig.(*HDR); !ok {
+		return ""
+	}
+
+	return fmt.Sprintf("%+.1f EV %s", v.exposure, toneMapNames[v.tonemap])
+}
+
+// deep checks if image has more than 8 bits per channel.
+func deep(img image.Image) bool {
+	switch img.ColorModel() {
+	case color.RGBA64Model, color.NRGBA64Model, color.Gray16Model, color.Alpha16Model:
+		return true
+	}
+
+	return false
+}
+
+// bayer is 8x8 ordered dithering matrix.
+var bayer = [8][8]uint32{
+	{0, 32, 8, 40, 2, 34, 10, 42},
+	{48, 16, 56, 24, 50, 18, 58, 26},
+	{12, 44, 4, 36, 14, 46, 6, 38},
+	{60, 28, 52, 20, 62, 30, 54, 22},
+	{3, 35, 11, 43, 1, 33, 9, 41},
+	{51, 19, 59, 27, 49, 17, 57, 25},
+	{15, 47, 7, 39, 13, 45, 5, 37},
+	{63, 31, 55, 23, 61, 29, 53, 21},
+}
+
+// ditherFrame reduces rectangle r of 16-bit frame src to 8 bits in dst with ordered dithering.
+// Pattern is fixed to frame coordinates, so it does not move with animation frames.
+func ditherFrame(dst *image.RGBA, src *image.RGBA64, r image.Rectangle) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			// threshold is in the middle of matrix cell, scaled to 16 bits
+			t := (bayer[y&7][x&7]*2 + 1) * 0xffff / 128
+
+			si := src.PixOffset(x, y)
+			di := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				v := uint32(src.Pix[si+c*2])<<8 | uint32(src.Pix[si+c*2+1])
+				dst.Pix[di+c] = uint8((v*255 + t) / 0xffff)
+			}
+		}
+	}
+}
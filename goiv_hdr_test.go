@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// exrFile returns header of scanline OpenEXR with one float channel and data window of size.
+func exrFile(width, height int) string {
+	var b bytes.Buffer
+	b.WriteString(exrMagic + "\x02\x00\x00\x00")
+
+	attr := func(name, typ string, value []byte) {
+		b.WriteString(name + "\x00" + typ + "\x00")
+		binary.Write(&b, binary.LittleEndian, uint32(len(value)))
+		b.Write(value)
+	}
+
+	channel := append([]byte("R\x00"), make([]byte, 16)...)
+	binary.LittleEndian.PutUint32(channel[2:], 2)
+	binary.LittleEndian.PutUint32(channel[10:], 1)
+	binary.LittleEndian.PutUint32(channel[14:], 1)
+	attr("channels", "chlist", append(channel, 0))
+
+	attr("compression", "compression", []byte{0})
+
+	window := make([]byte, 16)
+	binary.LittleEndian.PutUint32(window[8:], uint32(width-1))
+	binary.LittleEndian.PutUint32(window[12:], uint32(height-1))
+	attr("dataWindow", "box2i", window)
+	attr("displayWindow", "box2i", window)
+
+	b.WriteByte(0)
+
+	return b.String()
+}
+
+func TestDecodeHDRSize(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		ok   bool
+	}{
+		{"radiance", "#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n\n-Y 1 +X 2\n\x80\x80\x80\x81\x80\x80\x80\x81", true},
+		{"radiance too large", "#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n\n-Y 60000 +X 60000\n", false},
+		{"exr too large", exrFile(60000, 60000), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := decodeHDR([]byte(tt.data))
+
+			if tt.ok && (err != nil || img == nil) {
+				t.Fatalf("got %v, %v", img, err)
+			}
+
+			if !tt.ok && (err == nil || !strings.Contains(err.Error(), "invalid size")) {
+				t.Errorf("got %v, want invalid size", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// displayHeadless renders images into memory, keys are read from script and frames are written to out as PNG.
+// If out contains a format verb, i.e. frame%03d.png, every frame is written, otherwise only the final one.
+func displayHeadless(images []string, opts *Options, script io.Reader, out string) error {
+	h := &headless{}
+	h.frame = image.NewRGBA(image.Rect(0, 0, opts.Width, opts.Height))
+	h.out = out
+
+	// every frame is drawn with the selected filter, animations are stepped with keys
+	opts.Preview = false
+	opts.Animate = false
+
+	v := NewViewer(images, opts, h)
+
+	err := v.Update()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+	}
+
+	h.run(v)
+
+	if script != nil {
+		scanner := bufio.NewScanner(script)
+		scanner.Split(bufio.ScanWords)
+
+		for !h.quit && scanner.Scan() {
+			v.Key(keyName(scanner.Text()))
+			h.run(v)
+		}
+
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("script: %s", err.Error())
+		}
+	}
+
+	if h.out != "" && !h.sequence() {
+		return h.write(h.out)
+	}
+
+	return h.err
+}
+
+// headless is offscreen display backend.
+type headless struct {
+	frame  *image.RGBA
+	frames int
+	out    string
+	quit   bool
+	err    error
+
+	mu    sync.Mutex
+	queue []func()
+}
+
+// Size returns frame size.
+func (h *headless) Size() (int, int) {
+	return h.frame.Rect.Dx(), h.frame.Rect.Dy()
+}
+
+// Draw copies image to frame and writes it out when dumping all frames.
+func (h *headless) Draw(img image.Image, title string) error {
+	draw.Draw(h.frame, h.frame.Bounds(), img, image.ZP, draw.Src)
+	h.frames++
+
+	if h.out != "" && h.sequence() {
+		err := h.write(fmt.Sprintf(h.out, h.frames))
+		if err != nil && h.err == nil {
+			h.err = err
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Fullscreen does nothing.
+func (h *headless) Fullscreen() {
+}
+
+// Quit stops reading the script.
+func (h *headless) Quit() {
+	h.quit = true
+}
+
+// Status does nothing.
+func (h *headless) Status(title string) {
+}
+
+// Synchronize queues f, queued functions are run after each key.
+func (h *headless) Synchronize(f func()) {
+	h.mu.Lock()
+	h.queue = append(h.queue, f)
+	h.mu.Unlock()
+}
+
+// run waits for viewer to finish work in background and runs queued functions, so the script is deterministic.
+func (h *headless) run(v *Viewer) {
+	for {
+		v.Wait()
+
+		h.mu.Lock()
+		queue := h.queue
+		h.queue = nil
+		h.mu.Unlock()
+
+		if len(queue) == 0 {
+			return
+		}
+
+		for _, f := range queue {
+			f()
+		}
+	}
+}
+
+// sequence checks if every frame should be written.
+func (h *headless) sequence() bool {
+	return strings.Contains(h.out, "%")
+}
+
+// write encodes frame as PNG.
+func (h *headless) write(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	err = png.Encode(file, h.frame)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("%s: %s", filename, err.Error())
+	}
+
+	return file.Close()
+}
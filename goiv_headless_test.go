@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata/golden")
+
+// readPNG decodes PNG file.
+func readPNG(t *testing.T, filename string) image.Image {
+	t.Helper()
+
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	img, err := png.Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return img
+}
+
+func TestHeadlessGolden(t *testing.T) {
+	// source is 8x4, red left half and blue right half, white top-left and green bottom-right pixel
+	tests := []struct {
+		name   string
+		width  int
+		height int
+		mode   ViewMode
+		filter Filter
+		keys   string
+	}{
+		{"fit", 32, 32, ViewFit, FilterNearest, ""},
+		{"fit-bilinear", 32, 32, ViewFit, FilterBilinear, ""},
+		{"fit-width", 16, 20, ViewFitWidth, FilterNearest, ""},
+		{"fit-height", 20, 16, ViewFitHeight, FilterNearest, ""},
+		{"actual", 21, 13, ViewActual, FilterNearest, ""},
+		{"shrink", 24, 24, ViewShrink, FilterNearest, ""},
+		{"shrink-larger", 4, 4, ViewShrink, FilterNearest, ""},
+		{"zoom-in", 24, 24, ViewActual, FilterNearest, "plus plus plus plus"},
+		{"zoom-out", 24, 24, ViewFit, FilterNearest, "minus"},
+		{"zoom-pan", 24, 24, ViewFit, FilterNearest, "plus plus L L K"},
+		{"pan-clamped", 24, 24, ViewFit, FilterNearest, "plus plus H H H H H H"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := defaultOptions()
+			opts.Width, opts.Height = tt.width, tt.height
+			opts.Mode = tt.mode
+			opts.Filter = tt.filter
+
+			out := filepath.Join(t.TempDir(), "frame.png")
+			golden := filepath.Join("testdata", "golden", tt.name+".png")
+
+			err := displayHeadless([]string{filepath.Join("testdata", "golden", "source.png")}, opts, strings.NewReader(tt.keys), out)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := readPNG(t, out)
+
+			if *update {
+				data, err := ioutil.ReadFile(out)
+				if err == nil {
+					err = ioutil.WriteFile(golden, data, 0644)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want := readPNG(t, golden)
+
+			if got.Bounds() != want.Bounds() {
+				t.Fatalf("got size %v, want %v", got.Bounds(), want.Bounds())
+			}
+
+			b := want.Bounds()
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					r1, g1, b1, a1 := got.At(x, y).RGBA()
+					r2, g2, b2, a2 := want.At(x, y).RGBA()
+					if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+						t.Fatalf("pixel %d,%d: got %v, want %v", x, y, got.At(x, y), want.At(x, y))
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client downloads URLs with timeouts, retries, custom headers and auth, responses with ETag or Last-Modified
+// are kept in disk cache and revalidated.
+type Client struct {
+	Timeout     time.Duration // connect and response header timeout, 0 is no timeout
+	ReadTimeout time.Duration // maximal time without data while reading body, 0 is no timeout
+	Retries     int
+	Backoff     time.Duration // delay before first retry, doubled for each next
+	Header      http.Header
+	CacheDir    string // empty disables disk cache
+
+	// basic auth, or bearer token if set, URLs with user info use their own
+	// Authorization header is sent only to hosts, and not on redirect to other host
+	User     string
+	Password string
+	Token    string
+	Hosts    []string // hosts of URL arguments when empty
+
+	once   sync.Once
+	client *http.Client
+}
+
+// newClient returns client with default settings.
+func newClient() *Client {
+	c := &Client{}
+	c.Timeout = 10 * time.Second
+	c.ReadTimeout = 30 * time.Second
+	c.Retries = 3
+	c.Backoff = 500 * time.Millisecond
+	c.Header = http.Header{}
+	c.Header.Set("User-Agent", appName+"/"+appVersion)
+	c.CacheDir = cachePath()
+
+	return c
+}
+
+// cachePath returns path to cache of downloads, $XDG_CACHE_HOME/goiv/http.
+func cachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, appName, "http")
+}
+
+// set sets option of [http] config section, other names are request headers.
+func (c *Client) set(name, value string) error {
+	var err error
+
+	switch name {
+	case "timeout":
+		c.Timeout, err = time.ParseDuration(value)
+	case "read-timeout":
+		c.ReadTimeout, err = time.ParseDuration(value)
+	case "retries":
+		c.Retries, err = strconv.Atoi(value)
+	case "backoff":
+		c.Backoff, err = time.ParseDuration(value)
+	case "cache":
+		if value == "off" {
+			value = ""
+		}
+		c.CacheDir = value
+	case "hosts":
+		c.Hosts = nil
+		for _, h := range strings.Split(value, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				c.Hosts = append(c.Hosts, h)
+			}
+		}
+	case "user":
+		c.User = value
+	case "password":
+		c.Password = value
+	case "token":
+		c.Token = value
+	default:
+		c.Header.Set(name, value)
+	}
+
+	if err != nil || c.Timeout < 0 || c.ReadTimeout < 0 || c.Retries < 0 || c.Backoff < 0 {
+		return fmt.Errorf("invalid %s %s", name, value)
+	}
+
+	return nil
+}
+
+// loadEnv sets auth from GOIV_HTTP_USER and GOIV_HTTP_PASSWORD, or GOIV_HTTP_TOKEN, it overrides config.
+func (c *Client) loadEnv() {
+	if u := os.Getenv("GOIV_HTTP_USER"); u != "" {
+		c.User, c.Password = u, os.Getenv("GOIV_HTTP_PASSWORD")
+	}
+
+	if t := os.Getenv("GOIV_HTTP_TOKEN"); t != "" {
+		c.Token = t
+	}
+}
+
+// authorize sets hosts auth is sent to from URLs in args, unless hosts are set in config.
+// Pages and images found by crawl on other hosts are downloaded without auth.
+func (c *Client) authorize(args []string) {
+	if len(c.Hosts) > 0 {
+		return
+	}
+
+	for _, arg := range args {
+		if u, err := url.Parse(arg); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			c.Hosts = append(c.Hosts, u.Host)
+		}
+	}
+}
+
+// authorized checks if auth can be sent to host of URL, host can be given with or without port.
+func (c *Client) authorized(u *url.URL) bool {
+	for _, h := range c.Hosts {
+		if strings.EqualFold(h, u.Host) || strings.EqualFold(h, u.Hostname()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// httpClient returns HTTP client with connect timeouts and cookie jar, shared by all downloads.
+func (c *Client) httpClient() *http.Client {
+	c.once.Do(func() {
+		dialer := &net.Dialer{Timeout: c.Timeout, KeepAlive: 30 * time.Second}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.DialContext = dialer.DialContext
+		transport.TLSHandshakeTimeout = c.Timeout
+		transport.ResponseHeaderTimeout = c.Timeout
+
+		jar, _ := cookiejar.New(nil)
+
+		c.client = &http.Client{Transport: transport, Jar: jar}
+	})
+
+	return c.client
+}
+
+// Download returns bytes from URL, failed requests and server errors are retried with backoff.
+func (c *Client) Download(ctx context.Context, url string, progress progressFunc) ([]byte, error) {
+	b, _, err := c.Fetch(ctx, url, progress)
+	return b, err
+}
+
+// Fetch returns bytes from URL and final URL after redirects, which is base of relative links in it.
+func (c *Client) Fetch(ctx context.Context, url string, progress progressFunc) ([]byte, string, error) {
+	cached, validators := c.cached(url)
+
+	delay := c.Backoff
+	for attempt := 0; ; attempt++ {
+		b, final, err := c.get(ctx, url, cached, validators, progress)
+		if err == nil {
+			return b, final, nil
+		}
+
+		r, ok := err.(*retryError)
+		if !ok || attempt >= c.Retries || ctx.Err() != nil {
+			return nil, "", fmt.Errorf("%s: %s", url, err)
+		}
+
+		wait := r.wait
+		if wait < delay {
+			wait = delay
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, "", fmt.Errorf("%s: %s", url, ctx.Err())
+		case <-time.After(wait):
+		}
+
+		delay *= 2
+	}
+}
+
+// retryError is error of request which can be retried, after delay requested by server.
+type retryError struct {
+	err  error
+	wait time.Duration
+}
+
+// Error implements error.
+func (e *retryError) Error() string {
+	return e.err.Error()
+}
+
+// get makes one request, it returns bytes and final URL.
+func (c *Client) get(ctx context.Context, url string, cached []byte, validators http.Header, progress progressFunc) ([]byte, string, error) {
+	// body is read until there is no data for read timeout
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for k, v := range c.Header {
+		req.Header[k] = v
+	}
+
+	for k, v := range validators {
+		req.Header[k] = v
+	}
+
+	if c.authorized(req.URL) {
+		if c.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.Token)
+		} else if c.User != "" && req.URL.User == nil {
+			req.SetBasicAuth(c.User, c.Password)
+		}
+	}
+
+	res, err := c.httpClient().Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, "", &retryError{err, 0}
+	}
+
+	defer res.Body.Close()
+
+	final := res.Request.URL.String()
+
+	switch {
+	case res.StatusCode == http.StatusNotModified && cached != nil:
+		if progress != nil {
+			progress(int64(len(cached)), int64(len(cached)))
+		}
+
+		return cached, final, nil
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		wait := time.Duration(0)
+		if s, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && s <= 60 {
+			wait = time.Duration(s) * time.Second
+		}
+
+		return nil, "", &retryError{fmt.Errorf("%s", res.Status), wait}
+	case res.StatusCode != http.StatusOK:
+		return nil, "", fmt.Errorf("%s", res.Status)
+	}
+
+	var body io.Reader = res.Body
+
+	var timer *time.Timer
+	if c.ReadTimeout > 0 {
+		timer = time.AfterFunc(c.ReadTimeout, cancel)
+		defer timer.Stop()
+
+		body = &idleReader{res.Body, timer, c.ReadTimeout}
+	}
+
+	b, err := ioutil.ReadAll(newReader(ctx, body, res.ContentLength, progress))
+	if err != nil {
+		if timer != nil && !timer.Stop() {
+			err = fmt.Errorf("no data for %s", c.ReadTimeout)
+		}
+
+		return nil, "", &retryError{err, 0}
+	}
+
+	if !strings.Contains(res.Header.Get("Cache-Control"), "no-store") {
+		c.store(url, res.Header, b)
+	}
+
+	return b, final, nil
+}
+
+// idleReader resets timer after every read.
+type idleReader struct {
+	r       io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+// Read implements io.Reader.
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+	}
+
+	return n, err
+}
+
+// cacheFile returns path of cached response for URL.
+func (c *Client) cacheFile(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(c.CacheDir, hex.EncodeToString(sum[:]))
+}
+
+// cached returns cached body of URL and headers to revalidate it, cached response has header lines before the body.
+func (c *Client) cached(url string) ([]byte, http.Header) {
+	if c.CacheDir == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(c.cacheFile(url))
+	if err != nil {
+		return nil, nil
+	}
+
+	i := bytes.Index(data, []byte("\r\n\r\n"))
+	if i < 0 {
+		return nil, nil
+	}
+
+	h, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(data[:i+4]))).ReadMIMEHeader()
+	if err != nil {
+		return nil, nil
+	}
+
+	validators := http.Header{}
+	if v := h.Get("Etag"); v != "" {
+		validators.Set("If-None-Match", v)
+	}
+
+	if v := h.Get("Last-Modified"); v != "" {
+		validators.Set("If-Modified-Since", v)
+	}
+
+	return data[i+4:], validators
+}
+
+// store writes response with ETag or Last-Modified to cache, errors are ignored, cache is optional.
+func (c *Client) store(url string, header http.Header, body []byte) {
+	if c.CacheDir == "" || (header.Get("Etag") == "" && header.Get("Last-Modified") == "") {
+		return
+	}
+
+	if err := os.MkdirAll(c.CacheDir, 0700); err != nil {
+		return
+	}
+
+	var buf bytes.Buffer
+	for _, k := range []string{"Etag", "Last-Modified"} {
+		if v := header.Get(k); v != "" {
+			fmt.Fprintf(&buf, "%s: %s\r\n", k, v)
+		}
+	}
+	buf.WriteString("\r\n")
+	buf.Write(body)
+
+	// written to temporary file and renamed, so concurrent reads see whole file
+	f, err := ioutil.TempFile(c.CacheDir, ".tmp")
+	if err != nil {
+		return
+	}
+
+	_, err = f.Write(buf.Bytes())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+		return
+	}
+
+	if err := os.Rename(f.Name(), c.cacheFile(url)); err != nil {
+		os.Remove(f.Name())
+	}
+}
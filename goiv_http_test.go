@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testClient returns client with short backoff and cache in temporary directory.
+func testClient(t *testing.T) *Client {
+	c := newClient()
+	c.Backoff = 10 * time.Millisecond
+	c.CacheDir = t.TempDir()
+
+	return c
+}
+
+func TestClientRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		retryAt  string
+		requests int32
+		ok       bool
+		elapsed  time.Duration
+	}{
+		{"server error", []int{500, 200}, "", 2, true, 10 * time.Millisecond},
+		{"backoff doubled", []int{502, 503, 200}, "", 3, true, 30 * time.Millisecond},
+		{"too many requests", []int{429, 200}, "1", 2, true, time.Second},
+		{"retries exceeded", []int{500, 500, 500, 500, 500}, "", 4, false, 70 * time.Millisecond},
+		{"not found", []int{404, 200}, "", 1, false, 0},
+		{"forbidden", []int{403, 200}, "", 1, false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&requests, 1)
+
+				status := tt.statuses[n-1]
+				if status == http.StatusTooManyRequests {
+					w.Header().Set("Retry-After", tt.retryAt)
+				}
+
+				w.WriteHeader(status)
+				w.Write([]byte("image"))
+			}))
+			defer ts.Close()
+
+			start := time.Now()
+			b, err := testClient(t).Download(context.Background(), ts.URL, nil)
+			elapsed := time.Since(start)
+
+			if tt.ok && (err != nil || string(b) != "image") {
+				t.Errorf("got %q, %v", b, err)
+			}
+
+			if !tt.ok && err == nil {
+				t.Errorf("got %q, want error", b)
+			}
+
+			if requests != tt.requests {
+				t.Errorf("got %d requests, want %d", requests, tt.requests)
+			}
+
+			if elapsed < tt.elapsed {
+				t.Errorf("retried after %s, want at least %s", elapsed, tt.elapsed)
+			}
+		})
+	}
+}
+
+func TestClientHeaders(t *testing.T) {
+	var header http.Header
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		w.Write([]byte("image"))
+	})
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	other := httptest.NewServer(handler)
+	defer other.Close()
+
+	tests := []struct {
+		name   string
+		url    string
+		config map[string]string
+		auth   string
+	}{
+		{"no auth", ts.URL, map[string]string{}, ""},
+		{"basic", ts.URL, map[string]string{"user": "user", "password": "pass"}, "Basic dXNlcjpwYXNz"},
+		{"bearer", ts.URL, map[string]string{"user": "user", "token": "secret"}, "Bearer secret"},
+		{"other host", other.URL, map[string]string{"token": "secret"}, ""},
+		{"configured host", other.URL, map[string]string{"token": "secret", "hosts": "example.com, " + other.Listener.Addr().String()}, "Bearer secret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testClient(t)
+			c.set("X-Test", "value")
+
+			for k, v := range tt.config {
+				if err := c.set(k, v); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			c.authorize([]string{ts.URL + "/a.jpg"})
+
+			if _, err := c.Download(context.Background(), tt.url, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := header.Get("Authorization"); got != tt.auth {
+				t.Errorf("got Authorization %q, want %q", got, tt.auth)
+			}
+
+			if got := header.Get("X-Test"); got != "value" {
+				t.Errorf("got X-Test %q, want value", got)
+			}
+
+			if got := header.Get("User-Agent"); !strings.HasPrefix(got, appName+"/") {
+				t.Errorf("got User-Agent %q", got)
+			}
+		})
+	}
+}
+
+func TestClientReadTimeout(t *testing.T) {
+	stall := make(chan struct{})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "10")
+		w.Write([]byte("imag"))
+		w.(http.Flusher).Flush()
+
+		select {
+		case <-stall:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(stall)
+
+	c := testClient(t)
+	c.ReadTimeout = 50 * time.Millisecond
+	c.Retries = 0
+
+	_, err := c.Download(context.Background(), ts.URL, nil)
+	if err == nil || !strings.Contains(err.Error(), "no data for") {
+		t.Errorf("got %v, want read timeout", err)
+	}
+
+	if err := c.set("read-timeout", "-1s"); err == nil {
+		t.Error("negative read-timeout accepted")
+	}
+}
+
+func TestClientNoReadTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("image"))
+	}))
+	defer ts.Close()
+
+	c := testClient(t)
+	if err := c.set("read-timeout", "0"); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := c.Download(context.Background(), ts.URL, nil)
+	if err != nil || string(b) != "image" {
+		t.Errorf("got %q, %v", b, err)
+	}
+}
+
+func TestClientCache(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    string
+		value     string
+		validator string
+		noStore   bool
+	}{
+		{"etag", "ETag", `"v1"`, "If-None-Match", false},
+		{"last modified", "Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT", "If-Modified-Since", false},
+		{"no store", "ETag", `"v1"`, "If-None-Match", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests, revalidated int32
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&requests, 1)
+
+				if r.Header.Get(tt.validator) == tt.value {
+					atomic.AddInt32(&revalidated, 1)
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+
+				w.Header().Set(tt.header, tt.value)
+				if tt.noStore {
+					w.Header().Set("Cache-Control", "no-store")
+				}
+
+				w.Write([]byte("image"))
+			}))
+			defer ts.Close()
+
+			c := testClient(t)
+
+			for i := 0; i < 2; i++ {
+				b, err := c.Download(context.Background(), ts.URL, nil)
+				if err != nil || string(b) != "image" {
+					t.Fatalf("request %d: got %q, %v", i+1, b, err)
+				}
+			}
+
+			want := int32(1)
+			if tt.noStore {
+				want = 0
+			}
+
+			if requests != 2 || revalidated != want {
+				t.Errorf("got %d requests, %d revalidated, want 2, %d", requests, revalidated, want)
+			}
+		})
+	}
+}
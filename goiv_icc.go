@@ -0,0 +1,581 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf16"
+)
+
+// Profile is ICC profile of RGB or gray color space with matrix and tone reproduction curves, or of CMYK color space with lookup table.
+type Profile struct {
+	Description string
+
+	gray   bool
+	matrix [3][3]float64 // RGB to XYZ, relative to D50
+	curves [3][]float64  // samples of curves from 0-1 to linear
+	lut    *iccLUT       // CMYK to XYZ
+
+	once    sync.Once
+	inverse [3][]uint16
+	fromXYZ [3][3]float64
+}
+
+// curveSize is number of samples of tone reproduction curve.
+const curveSize = 4096
+
+// d50 is white point of profile connection space.
+var d50 = [3]float64{0.9642, 1, 0.8249}
+
+// sRGB is profile used when display profile is not known.
+var sRGB = &Profile{
+	Description: "sRGB",
+	matrix: [3][3]float64{
+		{0.4360747, 0.3850649, 0.1430804},
+		{0.2225045, 0.7168786, 0.0606169},
+		{0.0139322, 0.0971045, 0.7141733},
+	},
+	curves: func() [3][]float64 {
+		c := sampleCurve(func(x float64) float64 {
+			if x <= 0.04045 {
+				return x / 12.92
+			}
+			return math.Pow((x+0.055)/1.055, 2.4)
+		})
+		return [3][]float64{c, c, c}
+	}(),
+}
+
+// Tagged is image with embedded ICC profile.
+type Tagged struct {
+	image.Image
+
+	Profile *Profile
+}
+
+// withProfile returns image tagged with profile embedded in data, or image itself if there is no supported profile.
+func withProfile(img image.Image, data []byte) image.Image {
+	icc := embeddedProfile(data)
+	if icc == nil {
+		return img
+	}
+
+	p, err := parseICC(icc)
+	if err != nil {
+		return img
+	}
+
+	return &Tagged{img, p}
+}
+
+// untag returns image and embedded profile of tagged image or page, profile is nil for other images.
+func untag(img image.Image) (image.Image, *Profile) {
+	if p, ok := img.(*Page); ok {
+		img = p.Image
+	}
+
+	if t, ok := img.(*Tagged); ok {
+		return t.Image, t.Profile
+	}
+
+	return img, nil
+}
+
+// manage converts image with embedded profile to display profile, image is returned as it is when display is nil.
+func manage(img image.Image, display *Profile) image.Image {
+	src, p := untag(img)
+	if p == nil || display == nil {
+		return img
+	}
+
+	return p.Convert(src, display)
+}
+
+// embeddedProfile returns ICC profile embedded in JPEG, PNG, TIFF or WebP.
+func embeddedProfile(data []byte) []byte {
+	switch {
+	case bytes.HasPrefix(data, []byte("\xff\xd8")):
+		// profile can be split in several APP2 segments, with sequence number and count
+		chunks := make([][]byte, 0)
+		for _, s := range jpegSegments(data, 0xe2) {
+			if bytes.HasPrefix(s, []byte("ICC_PROFILE\x00")) && len(s) >= 14 {
+				chunks = append(chunks, s[12:])
+			}
+		}
+
+		sort.SliceStable(chunks, func(i, j int) bool {
+			return chunks[i][0] < chunks[j][0]
+		})
+
+		var icc []byte
+		for _, c := range chunks {
+			icc = append(icc, c[2:]...)
+		}
+
+		return icc
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		for i := 8; i+8 <= len(data); {
+			n := int(binary.BigEndian.Uint32(data[i:]))
+			typ := string(data[i+4 : i+8])
+			if n < 0 || i+12+n > len(data) || typ == "IDAT" {
+				return nil
+			}
+
+			// name, compression method and deflated profile
+			if typ == "iCCP" {
+				chunk := data[i+8 : i+8+n]
+				z := bytes.IndexByte(chunk, 0)
+				if z < 0 || z+2 > len(chunk) || chunk[z+1] != 0 {
+					return nil
+				}
+
+				r, err := zlib.NewReader(bytes.NewReader(chunk[z+2:]))
+				if err != nil {
+					return nil
+				}
+
+				icc, err := ioutil.ReadAll(r)
+				if err != nil {
+					return nil
+				}
+
+				return icc
+			}
+
+			i += 12 + n
+		}
+	case bytes.HasPrefix(data, []byte("II*\x00")), bytes.HasPrefix(data, []byte("MM\x00*")):
+		order := tiffOrder(data)
+		return ifdBytes(data, order, int(order.Uint32(data[4:])), 0x8773)
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		for i := 12; i+8 <= len(data); {
+			n := int(binary.LittleEndian.Uint32(data[i+4:]))
+			if n < 0 || i+8+n > len(data) {
+				return nil
+			}
+
+			if string(data[i:i+4]) == "ICCP" {
+				return data[i+8 : i+8+n]
+			}
+
+			i += 8 + n + n&1
+		}
+	}
+
+	return nil
+}
+
+// parseICC parses ICC profile, only matrix and curves of RGB and gray profiles, and lookup tables of CMYK profiles are supported.
+func parseICC(data []byte) (*Profile, error) {
+	be := binary.BigEndian
+
+	if len(data) < 132 || string(data[36:40]) != "acsp" {
+		return nil, fmt.Errorf("icc: invalid profile")
+	}
+
+	if n := int(be.Uint32(data)); n >= 132 && n < len(data) {
+		data = data[:n]
+	}
+
+	tags := make(map[string][]byte)
+	count := int(be.Uint32(data[128:]))
+	for i := 0; i < count && 132+i*12+12 <= len(data); i++ {
+		e := data[132+i*12:]
+		off, size := int(be.Uint32(e[4:])), int(be.Uint32(e[8:]))
+		if off < 0 || size < 0 || off > len(data) || size > len(data)-off {
+			return nil, fmt.Errorf("icc: invalid tag %s", e[:4])
+		}
+
+		tags[string(e[:4])] = data[off : off+size]
+	}
+
+	p := &Profile{Description: iccText(tags["desc"])}
+
+	switch cs := string(data[16:20]); cs {
+	case "RGB ":
+		for i, name := range []string{"r", "g", "b"} {
+			xyz, err := iccXYZ(tags[name+"XYZ"])
+			if err != nil {
+				return nil, err
+			}
+
+			p.curves[i], err = iccCurve(tags[name+"TRC"])
+			if err != nil {
+				return nil, err
+			}
+
+			for j := range xyz {
+				p.matrix[j][i] = xyz[j]
+			}
+		}
+	case "GRAY":
+		c, err := iccCurve(tags["kTRC"])
+		if err != nil {
+			return nil, err
+		}
+
+		// gray is drawn as equal red, green and blue, which together map to white
+		p.gray = true
+		p.curves = [3][]float64{c, c, c}
+		for i := range p.matrix {
+			p.matrix[i] = [3]float64{d50[i] / 3, d50[i] / 3, d50[i] / 3}
+		}
+	case "CMYK":
+		// perceptual, or colorimetric table
+		tag := tags["A2B0"]
+		if tag == nil {
+			tag = tags["A2B1"]
+		}
+
+		var err error
+		p.lut, err = parseLUT(tag, string(data[20:24]))
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("icc: unsupported color space %s", strings.TrimSpace(cs))
+	}
+
+	return p, nil
+}
+
+// parseDisplayProfile parses ICC profile of display, it has to be RGB profile.
+func parseDisplayProfile(data []byte) (*Profile, error) {
+	p, err := parseICC(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.gray || p.lut != nil {
+		return nil, fmt.Errorf("icc: display profile is not RGB")
+	}
+
+	return p, nil
+}
+
+// iccXYZ reads XYZ tag.
+func iccXYZ(tag []byte) ([3]float64, error) {
+	var xyz [3]float64
+	if len(tag) < 20 || string(tag[:4]) != "XYZ " {
+		return xyz, fmt.Errorf("icc: unsupported profile, missing colorants")
+	}
+
+	for i := range xyz {
+		xyz[i] = s15Fixed16(tag[8+i*4:])
+	}
+
+	return xyz, nil
+}
+
+// iccCurve reads curve or parametric curve tag.
+func iccCurve(tag []byte) ([]float64, error) {
+	be := binary.BigEndian
+
+	if len(tag) >= 12 && string(tag[:4]) == "curv" {
+		n := int(be.Uint32(tag[8:]))
+		switch {
+		case n == 0:
+			return sampleCurve(func(x float64) float64 { return x }), nil
+		case n == 1 && len(tag) >= 14:
+			g := float64(be.Uint16(tag[12:])) / 256
+			return sampleCurve(func(x float64) float64 { return math.Pow(x, g) }), nil
+		case n > 1 && len(tag) >= 12+n*2:
+			return sampleCurve(func(x float64) float64 {
+				f := x * float64(n-1)
+				i := int(f)
+				if i >= n-1 {
+					return float64(be.Uint16(tag[12+(n-1)*2:])) / 0xffff
+				}
+
+				a, b := float64(be.Uint16(tag[12+i*2:])), float64(be.Uint16(tag[14+i*2:]))
+				return (a + (b-a)*(f-float64(i))) / 0xffff
+			}), nil
+		}
+	}
+
+	if len(tag) >= 12 && string(tag[:4]) == "para" {
+		typ := int(be.Uint16(tag[8:]))
+		n := []int{1, 3, 4, 5, 7}
+		if typ >= len(n) || len(tag) < 12+n[typ]*4 {
+			return nil, fmt.Errorf("icc: unsupported parametric curve")
+		}
+
+		// gamma, a, b, c, d, e, f, missing parameters are such that curve is continuous
+		g := [7]float64{1, 1, 0, 0, 0, 0, 0}
+		for i := 0; i < n[typ]; i++ {
+			g[i] = s15Fixed16(tag[12+i*4:])
+		}
+
+		switch typ {
+		case 1, 2:
+			if g[1] != 0 {
+				g[4] = -g[2] / g[1]
+			}
+			g[5], g[6] = g[3], g[3]
+			g[3] = 0
+		case 3:
+			g[5], g[6] = 0, 0
+		case 0:
+			g[4] = 0
+		}
+
+		return sampleCurve(func(x float64) float64 {
+			if x < g[4] {
+				return g[3]*x + g[6]
+			}
+			return math.Pow(math.Max(0, g[1]*x+g[2]), g[0]) + g[5]
+		}), nil
+	}
+
+	return nil, fmt.Errorf("icc: unsupported profile, missing curves")
+}
+
+// iccText reads text of description tag, ASCII in version 2 or first of localized strings in version 4.
+func iccText(tag []byte) string {
+	be := binary.BigEndian
+
+	switch {
+	case len(tag) >= 12 && string(tag[:4]) == "desc":
+		n := int(be.Uint32(tag[8:]))
+		if n > len(tag)-12 {
+			n = len(tag) - 12
+		}
+
+		return strings.TrimRight(string(tag[12:12+n]), "\x00")
+	case len(tag) >= 28 && string(tag[:4]) == "mluc":
+		n, off := int(be.Uint32(tag[20:])), int(be.Uint32(tag[24:]))
+		if off < 0 || n < 0 || off > len(tag) || n > len(tag)-off {
+			return ""
+		}
+
+		u := make([]uint16, n/2)
+		for i := range u {
+			u[i] = be.Uint16(tag[off+i*2:])
+		}
+
+		return strings.TrimRight(string(utf16.Decode(u)), "\x00")
+	}
+
+	return ""
+}
+
+// s15Fixed16 reads signed fixed point number.
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// sampleCurve returns samples of function f in range 0-1.
+func sampleCurve(f func(x float64) float64) []float64 {
+	c := make([]float64, curveSize)
+	for i := range c {
+		c[i] = f(float64(i) / (curveSize - 1))
+	}
+
+	return c
+}
+
+// linear returns value of curve at x, in range 0-1.
+func linear(c []float64, x float64) float64 {
+	f := x * (curveSize - 1)
+	i := int(f)
+	if i >= curveSize-1 {
+		return c[curveSize-1]
+	}
+
+	return c[i] + (c[i+1]-c[i])*(f-float64(i))
+}
+
+// prepare computes inverse of matrix and curves, to use profile as destination.
+func (p *Profile) prepare() {
+	p.once.Do(func() {
+		p.fromXYZ = invert(p.matrix)
+
+		for i, c := range p.curves {
+			// curves are monotonic, inverse is found by walking samples
+			inv := make([]uint16, 1<<16)
+			j := 0
+			for k := range inv {
+				v := float64(k) / 0xffff
+				for j < curveSize-2 && c[j+1] < v {
+					j++
+				}
+
+				x := float64(j)
+				if d := c[j+1] - c[j]; d > 0 {
+					x += math.Max(0, math.Min(1, (v-c[j])/d))
+				}
+
+				inv[k] = uint16(x/(curveSize-1)*0xffff + 0.5)
+			}
+
+			p.inverse[i] = inv
+		}
+	})
+}
+
+// Convert returns image converted from profile to display profile, colors outside of display gamut are clipped.
+func (p *Profile) Convert(img image.Image, display *Profile) image.Image {
+	display.prepare()
+
+	if p.lut != nil {
+		return p.convertCMYK(img, display)
+	}
+
+	var m [3][3]float64
+	for i := range m {
+		for j := range m[i] {
+			for k := range m[i] {
+				m[i][j] += display.fromXYZ[i][k] * p.matrix[k][j]
+			}
+		}
+	}
+
+	// encode converts linear values to display values
+	encode := func(r, g, b float64) [3]uint32 {
+		var o [3]uint32
+		for i := range o {
+			v := m[i][0]*r + m[i][1]*g + m[i][2]*b
+			o[i] = uint32(display.inverse[i][int(math.Max(0, math.Min(1, v))*0xffff+0.5)])
+		}
+		return o
+	}
+
+	b := img.Bounds()
+
+	// pixels are premultiplied, colors of translucent pixels are converted without alpha
+	if deep(img) {
+		dst := image.NewRGBA64(b)
+		draw.Draw(dst, b, img, b.Min, draw.Src)
+
+		var lut [3][]float64
+		for i := range lut {
+			lut[i] = make([]float64, 1<<16)
+			for v := range lut[i] {
+				lut[i][v] = linear(p.curves[i], float64(v)/0xffff)
+			}
+		}
+
+		for i := 0; i+8 <= len(dst.Pix); i += 8 {
+			px := dst.Pix[i : i+8]
+			a := uint32(px[6])<<8 | uint32(px[7])
+			if a == 0 {
+				continue
+			}
+
+			var c [3]uint32
+			for j := range c {
+				c[j] = (uint32(px[j*2])<<8 | uint32(px[j*2+1])) * 0xffff / a
+			}
+
+			o := encode(lut[0][c[0]], lut[1][c[1]], lut[2][c[2]])
+			for j, v := range o {
+				v = v * a / 0xffff
+				px[j*2], px[j*2+1] = uint8(v>>8), uint8(v)
+			}
+		}
+
+		return dst
+	}
+
+	dst := image.NewRGBA(b)
+	draw.Draw(dst, b, img, b.Min, draw.Src)
+
+	var lut [3][256]float64
+	for i := range lut {
+		for v := range lut[i] {
+			lut[i][v] = linear(p.curves[i], float64(v)/0xff)
+		}
+	}
+
+	for i := 0; i+4 <= len(dst.Pix); i += 4 {
+		px := dst.Pix[i : i+4]
+		a := uint32(px[3])
+		if a == 0 {
+			continue
+		}
+
+		var c [3]uint32
+		for j := range c {
+			c[j] = uint32(px[j]) * 0xff / a
+		}
+
+		o := encode(lut[0][c[0]], lut[1][c[1]], lut[2][c[2]])
+		for j, v := range o {
+			px[j] = uint8((v*a/0xff + 0x80) / 0x101)
+		}
+	}
+
+	return dst
+}
+
+// invert returns inverse of matrix.
+func invert(m [3][3]float64) [3][3]float64 {
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+
+	var r [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			// cofactor of transposed element
+			a, b := (j+1)%3, (j+2)%3
+			c, d := (i+1)%3, (i+2)%3
+			r[i][j] = (m[a][c]*m[b][d] - m[a][d]*m[b][c]) / det
+		}
+	}
+
+	return r
+}
+
+// ToggleICC turns color management of images with embedded profile on or off.
+func (v *Viewer) ToggleICC() {
+	v.icc = !v.icc
+
+	if _, p := untag(v.orig); p == nil {
+		v.status()
+		return
+	}
+
+	v.img = transform(v.source(), v.orientations[v.Current()])
+
+	v.redraw()
+}
+
+// displayProfile returns profile images are converted to, or nil when color management is off.
+func (v *Viewer) displayProfile() *Profile {
+	if !v.icc {
+		return nil
+	}
+
+	if v.opts.Profile != nil {
+		return v.opts.Profile
+	}
+
+	return sRGB
+}
+
+// iccTitle returns description of embedded profile for title.
+func (v *Viewer) iccTitle() string {
+	_, p := untag(v.orig)
+	if p == nil {
+		return ""
+	}
+
+	if !v.icc {
+		return "icc off"
+	}
+
+	if p.Description == "" {
+		return "icc"
+	}
+
+	return p.Description
+}
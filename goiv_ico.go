@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"sort"
+)
+
+func init() {
+	image.RegisterFormat("ico", "\x00\x00\x01\x00", decodeICO, decodeICOConfig)
+	image.RegisterFormat("cur", "\x00\x00\x02\x00", decodeICO, decodeICOConfig)
+}
+
+// icoEntry is image in ICO or CUR file.
+type icoEntry struct {
+	width  int
+	height int
+	bpp    int
+	data   []byte
+}
+
+// icoEntries returns images in ICO or CUR file, largest first.
+func icoEntries(data []byte) ([]icoEntry, error) {
+	if len(data) < 6 || data[0] != 0 || data[1] != 0 || (data[2] != 1 && data[2] != 2) || data[3] != 0 {
+		return nil, fmt.Errorf("ico: invalid format")
+	}
+
+	count := int(binary.LittleEndian.Uint16(data[4:]))
+	if count == 0 || 6+count*16 > len(data) {
+		return nil, fmt.Errorf("ico: invalid number of images %d", count)
+	}
+
+	entries := make([]icoEntry, 0, count)
+	for i := 0; i < count; i++ {
+		e := data[6+i*16:]
+
+		size := int(binary.LittleEndian.Uint32(e[8:]))
+		offset := int(binary.LittleEndian.Uint32(e[12:]))
+		if size <= 0 || offset < 0 || offset+size > len(data) || offset+size < offset {
+			return nil, fmt.Errorf("ico: invalid image %d", i+1)
+		}
+
+		width, height := int(e[0]), int(e[1])
+		if width == 0 {
+			width = 256
+		}
+		if height == 0 {
+			height = 256
+		}
+
+		// for cursors it is hotspot, bit count is read from bitmap header
+		bpp := int(binary.LittleEndian.Uint16(e[6:]))
+		entry := data[offset : offset+size]
+		if len(entry) >= 16 && !bytes.HasPrefix(entry, pngSignature) {
+			bpp = int(binary.LittleEndian.Uint16(entry[14:]))
+		}
+
+		entries = append(entries, icoEntry{width, height, bpp, entry})
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.width*a.height != b.width*b.height {
+			return a.width*a.height > b.width*b.height
+		}
+		return a.bpp > b.bpp
+	})
+
+	return entries, nil
+}
+
+// decodeICOConfig returns color model and size of the largest image in ICO or CUR file.
+func decodeICOConfig(r io.Reader) (image.Config, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	entries, err := icoEntries(data)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	return image.Config{ColorModel: color.NRGBAModel, Width: entries[0].width, Height: entries[0].height}, nil
+}
+
+// decodeICO decodes the largest image in ICO or CUR file.
+func decodeICO(r io.Reader) (image.Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := icoEntries(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries[0].decode()
+}
+
+// decodeICOPage decodes image of ICO or CUR file as page, largest image is the first page.
+// It returns nil if data is not ICO or it has only one image.
+func decodeICOPage(data []byte, page int) (*Page, error) {
+	if !bytes.HasPrefix(data, []byte("\x00\x00\x01\x00")) && !bytes.HasPrefix(data, []byte("\x00\x00\x02\x00")) {
+		return nil, nil
+	}
+
+	entries, err := icoEntries(data)
+	if err != nil || (len(entries) == 1 && page == 1) {
+		return nil, err
+	}
+
+	if page > len(entries) {
+		return nil, fmt.Errorf("page %d of %d", page, len(entries))
+	}
+
+	img, err := entries[page-1].decode()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Page{img, page, len(entries)}, nil
+}
+
+// decode decodes PNG or bitmap image.
+func (e icoEntry) decode() (image.Image, error) {
+	if bytes.HasPrefix(e.data, pngSignature) {
+		return png.Decode(bytes.NewReader(e.data))
+	}
+
+	return decodeDIB(e.data)
+}
+
+// decodeDIB decodes device independent bitmap without file header, as stored in ICO.
+// Bitmap has double height, color image is followed by 1-bit transparency mask.
+func decodeDIB(data []byte) (image.Image, error) {
+	if len(data) < 40 {
+		return nil, fmt.Errorf("ico: invalid bitmap header")
+	}
+
+	le := binary.LittleEndian
+
+	headerSize := int(le.Uint32(data))
+	width := int(int32(le.Uint32(data[4:])))
+	height := int(int32(le.Uint32(data[8:]))) / 2
+	bpp := int(le.Uint16(data[14:]))
+	compression := le.Uint32(data[16:])
+	colors := int(le.Uint32(data[32:]))
+
+	if width <= 0 || height <= 0 || width > 1024 || height > 1024 || headerSize < 40 || headerSize > len(data) {
+		return nil, fmt.Errorf("ico: invalid bitmap size")
+	}
+
+	// only uncompressed bitmaps, bit fields of 32-bit images are the default BGRA
+	if compression != 0 && !(compression == 3 && bpp == 32) {
+		return nil, fmt.Errorf("ico: unsupported bitmap compression %d", compression)
+	}
+
+	var palette []color.NRGBA
+	offset := headerSize
+	if compression == 3 {
+		offset += 12
+	}
+
+	if bpp <= 8 {
+		if colors == 0 {
+			colors = 1 << uint(bpp)
+		}
+
+		if offset+colors*4 > len(data) {
+			return nil, fmt.Errorf("ico: invalid palette")
+		}
+
+		palette = make([]color.NRGBA, colors)
+		for i := range palette {
+			p := data[offset+i*4:]
+			palette[i] = color.NRGBA{p[2], p[1], p[0], 255}
+		}
+
+		offset += colors * 4
+	}
+
+	switch bpp {
+	case 1, 4, 8, 24, 32:
+	default:
+		return nil, fmt.Errorf("ico: unsupported bit count %d", bpp)
+	}
+
+	stride := (width*bpp + 31) / 32 * 4
+	maskStride := (width + 31) / 32 * 4
+
+	if offset+stride*height > len(data) {
+		return nil, fmt.Errorf("ico: invalid bitmap data")
+	}
+
+	// mask is optional for 32-bit images
+	mask := data[offset+stride*height:]
+	if len(mask) < maskStride*height {
+		mask = nil
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	alpha := false
+
+	for y := 0; y < height; y++ {
+		// rows are stored bottom-up
+		row := data[offset+(height-1-y)*stride:]
+
+		for x := 0; x < width; x++ {
+			var c color.NRGBA
+
+			switch bpp {
+			case 1, 4, 8:
+				bit := x * bpp
+				i := int(row[bit/8]>>uint(8-bpp-bit%8)) & (1<<uint(bpp) - 1)
+				if i < len(palette) {
+					c = palette[i]
+				}
+			case 24:
+				c = color.NRGBA{row[x*3+2], row[x*3+1], row[x*3], 255}
+			case 32:
+				c = color.NRGBA{row[x*4+2], row[x*4+1], row[x*4], row[x*4+3]}
+				if c.A != 0 {
+					alpha = true
+				}
+			}
+
+			img.SetNRGBA(x, y, c)
+		}
+	}
+
+	// 32-bit images without alpha and other images use transparency mask
+	if bpp == 32 && alpha || mask == nil {
+		return img, nil
+	}
+
+	for y := 0; y < height; y++ {
+		row := mask[(height-1-y)*maskStride:]
+
+		for x := 0; x < width; x++ {
+			i := img.PixOffset(x, y) + 3
+			if row[x/8]&(0x80>>uint(x%8)) != 0 {
+				img.Pix[i] = 0
+			} else {
+				img.Pix[i] = 255
+			}
+		}
+	}
+
+	return img, nil
+}
@@ -3,11 +3,9 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"image"
-	"io/ioutil"
-	"net/http"
-	"os"
 
 	_ "image/gif"
 	_ "image/jpeg"
@@ -28,66 +26,60 @@ func init() {
 	tga.RegisterFormat()
 }
 
-// decode decodes image.
-func decode(filename string, width, height int) (img image.Image, err error) {
-	if isURL(filename) {
-		img, err = decodeURL(filename)
-	} else {
-		img, err = decodeFile(filename)
-	}
-
+// decode decodes page of image from file or URL, it stops when context is done.
+func decode(ctx context.Context, filename string, page int, opts *Options, progress progressFunc) (image.Image, error) {
+	data, err := readData(ctx, filename, opts.HTTP, progress)
 	if err != nil {
 		return nil, err
 	}
 
-	return scale(img, width, height)
-}
+	// data URIs are shortened in messages
+	filename = displayName(filename)
 
-// decodeFile decodes image file.
-func decodeFile(filename string) (image.Image, error) {
-	file, err := os.Open(filename)
+	img, err := decodeExternal(ctx, opts.Decoders, filename, data)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %s", filename, err)
 	}
 
-	img, _, err := image.Decode(file)
-	if err != nil {
-		return nil, fmt.Errorf("%s: %s", filename, err)
+	if img != nil {
+		return img, nil
 	}
 
-	return img, nil
-}
-
-// decodeURL decodes image from URL.
-func decodeURL(url string) (image.Image, error) {
-	res, err := http.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("%s: %s", url, err)
+	for _, b := range builtins {
+		img, err = b.decode(ctx, data, page, opts)
+		if err == image.ErrFormat {
+			return nil, errUnknownFormat(filename, data)
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %s", filename, err)
+		}
+
+		if img != nil {
+			return img, nil
+		}
 	}
 
-	defer res.Body.Close()
-
-	img, _, err := image.Decode(res.Body)
-	if err != nil {
-		return nil, fmt.Errorf("%s: %s", url, err)
-	}
+	return nil, errUnknownFormat(filename, data)
+}
 
-	return img, nil
+// interpolations maps filters to resize interpolation functions.
+var interpolations = map[Filter]resize.InterpolationFunction{
+	FilterNearest:  resize.NearestNeighbor,
+	FilterBilinear: resize.Bilinear,
+	FilterBicubic:  resize.Bicubic,
+	FilterMitchell: resize.MitchellNetravali,
+	FilterLanczos2: resize.Lanczos2,
+	FilterLanczos3: resize.Lanczos3,
 }
 
-// downloadURL returns bytes from URL.
-func downloadURL(url string) ([]byte, error) {
-	res, err := http.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("%s: %s", url, err)
+// scale scales image to width and height.
+func scale(img image.Image, width, height int, filter Filter) (image.Image, error) {
+	if filter == FilterAuto {
+		if width < img.Bounds().Dx() || height < img.Bounds().Dy() {
+			filter = FilterLanczos3
+		} else {
+			filter = FilterBilinear
+		}
 	}
 
-	defer res.Body.Close()
-
-	return ioutil.ReadAll(res.Body)
-}
-
-// scale scales image keeping aspect ratio.
-func scale(img image.Image, width, height int) (image.Image, error) {
-	return resize.Resize(0, uint(height), img, resize.NearestNeighbor), nil
+	return resize.Resize(uint(width), uint(height), img, interpolations[filter]), nil
 }
@@ -0,0 +1,262 @@
+// +build imagick
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+
+	"gopkg.in/gographics/imagick.v2/imagick"
+)
+
+func init() {
+	imagick.Initialize()
+}
+
+// decode decodes page of image from file or URL with ImageMagick, it stops when context is done.
+// Built-in decoders marked with magick, i.e. of animations that are played, are tried before it.
+func decode(ctx context.Context, filename string, page int, opts *Options, progress progressFunc) (image.Image, error) {
+	data, err := readData(ctx, filename, opts.HTTP, progress)
+	if err != nil {
+		return nil, err
+	}
+
+	// data URIs are shortened in messages
+	filename = displayName(filename)
+
+	img, err := decodeExternal(ctx, opts.Decoders, filename, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	if img != nil {
+		return img, nil
+	}
+
+	for _, b := range builtins {
+		if !b.magick {
+			continue
+		}
+
+		img, err = b.decode(ctx, data, page, opts)
+		if err == nil && img != nil {
+			return img, nil
+		}
+	}
+
+	mw := imagick.NewMagickWand()
+	defer mw.Destroy()
+
+	// name is hint for formats without magic bytes, i.e. camera RAW
+	err = mw.SetFilename(filename)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	err = mw.ReadImageBlob(data)
+	if err != nil && strings.Contains(err.Error(), "no decode delegate") {
+		return nil, errUnknownFormat(filename, data)
+	} else if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	count := int(mw.GetNumberImages())
+	if page > count {
+		return nil, fmt.Errorf("%s: page %d of %d", filename, page, count)
+	}
+
+	mw.SetIteratorIndex(page - 1)
+
+	if opts.Exif {
+		err = mw.AutoOrientImage()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", filename, err)
+		}
+	}
+
+	img, err = wandImage(mw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	// HDR is in linear RGB already
+	if _, ok := img.(*HDR); !ok {
+		if p, err := parseICC([]byte(mw.GetImageProfile("icc"))); err == nil {
+			img = &Tagged{img, p}
+		}
+	}
+
+	if count > 1 {
+		return &Page{img, page, count}, nil
+	}
+
+	return img, nil
+}
+
+// wandImage returns current image of wand, floating point images as HDR in linear RGB,
+// images deeper than 8 bits as 16-bit image and other images as 8-bit image, both in sRGB.
+func wandImage(mw *imagick.MagickWand) (image.Image, error) {
+	if wandFloat(mw) {
+		return wandHDR(mw)
+	}
+
+	err := mw.TransformImageColorspace(imagick.COLORSPACE_SRGB)
+	if err != nil {
+		return nil, err
+	}
+
+	width, height := mw.GetImageWidth(), mw.GetImageHeight()
+	if !validSize(int(width), int(height), 8) {
+		return nil, fmt.Errorf("invalid size %dx%d", width, height)
+	}
+
+	if mw.GetImageDepth() <= 8 {
+		pixels, err := mw.ExportImagePixels(0, 0, width, height, "RGBA", imagick.PIXEL_CHAR)
+		if err != nil {
+			return nil, err
+		}
+
+		img := image.NewNRGBA(image.Rect(0, 0, int(width), int(height)))
+		copy(img.Pix, pixels.([]byte))
+
+		return img, nil
+	}
+
+	pixels, err := mw.ExportImagePixels(0, 0, width, height, "RGBA", imagick.PIXEL_SHORT)
+	if err != nil {
+		return nil, err
+	}
+
+	img := image.NewNRGBA64(image.Rect(0, 0, int(width), int(height)))
+
+	// samples are native 16-bit integers, NRGBA64 is big endian
+	switch p := pixels.(type) {
+	case []int16:
+		for i, v := range p {
+			binary.BigEndian.PutUint16(img.Pix[2*i:], uint16(v))
+		}
+	case []uint16:
+		for i, v := range p {
+			binary.BigEndian.PutUint16(img.Pix[2*i:], v)
+		}
+	}
+
+	return img, nil
+}
+
+// wandFloat checks if current image of wand has floating point samples, i.e. Radiance, OpenEXR or float TIFF.
+func wandFloat(mw *imagick.MagickWand) bool {
+	switch mw.GetImageFormat() {
+	case "HDR", "EXR", "PFM":
+		return true
+	}
+
+	return mw.GetImageProperty("quantum:format") == "floating-point"
+}
+
+// wandHDR returns current image of wand as HDR with linear, premultiplied samples.
+func wandHDR(mw *imagick.MagickWand) (*HDR, error) {
+	err := mw.TransformImageColorspace(imagick.COLORSPACE_RGB)
+	if err != nil {
+		return nil, err
+	}
+
+	width, height := mw.GetImageWidth(), mw.GetImageHeight()
+	if !validSize(int(width), int(height), 16) {
+		return nil, fmt.Errorf("invalid size %dx%d", width, height)
+	}
+
+	pixels, err := mw.ExportImagePixels(0, 0, width, height, "RGBA", imagick.PIXEL_FLOAT)
+	if err != nil {
+		return nil, err
+	}
+
+	img := NewHDR(image.Rect(0, 0, int(width), int(height)))
+	copy(img.Pix, pixels.([]float32))
+
+	for i := 0; i < len(img.Pix); i += 4 {
+		a := img.Pix[i+3]
+		img.Pix[i] *= a
+		img.Pix[i+1] *= a
+		img.Pix[i+2] *= a
+	}
+
+	return img, nil
+}
+
+// filterTypes maps filters to ImageMagick filters.
+var filterTypes = map[Filter]imagick.FilterType{
+	FilterNearest:  imagick.FILTER_POINT,
+	FilterBilinear: imagick.FILTER_TRIANGLE,
+	FilterBicubic:  imagick.FILTER_CATROM,
+	FilterMitchell: imagick.FILTER_MITCHELL,
+	FilterLanczos2: imagick.FILTER_LANCZOS2,
+	FilterLanczos3: imagick.FILTER_LANCZOS,
+}
+
+// scale scales image to width and height with ImageMagick.
+func scale(img image.Image, width, height int, filter Filter) (image.Image, error) {
+	b := img.Bounds()
+
+	// auto is what ImageMagick uses by default, Lanczos for downscaling and Mitchell-Netravali for upscaling
+	if filter == FilterAuto {
+		if width < b.Dx() || height < b.Dy() {
+			filter = FilterLanczos3
+		} else {
+			filter = FilterMitchell
+		}
+	}
+
+	mw := imagick.NewMagickWand()
+	defer mw.Destroy()
+
+	var err error
+
+	// 16-bit images are scaled with 16-bit samples
+	switch img.ColorModel() {
+	case color.NRGBA64Model, color.RGBA64Model, color.Gray16Model:
+		src := image.NewNRGBA64(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+		pixels := make([]int16, len(src.Pix)/2)
+		for i := range pixels {
+			pixels[i] = int16(binary.BigEndian.Uint16(src.Pix[2*i:]))
+		}
+
+		err = mw.ConstituteImage(uint(b.Dx()), uint(b.Dy()), "RGBA", imagick.PIXEL_SHORT, pixels)
+		if err == nil {
+			err = mw.SetImageDepth(16)
+		}
+	default:
+		src, ok := img.(*image.NRGBA)
+		if !ok || src.Rect.Min != image.ZP || src.Stride != 4*b.Dx() {
+			src = image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+			draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+		}
+
+		err = mw.ConstituteImage(uint(b.Dx()), uint(b.Dy()), "RGBA", imagick.PIXEL_CHAR, src.Pix)
+		if err == nil {
+			err = mw.SetImageDepth(8)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = mw.ResizeImage(uint(width), uint(height), filterTypes[filter], 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return wandImage(mw)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/oov/psd"
+)
+
+// Layers is image composed of layers, i.e. PSD, where single image is expected it is merged image.
+type Layers struct {
+	image.Image
+
+	// Layers are ordered from bottom to top.
+	Layers []Layer
+	Rect   image.Rectangle
+}
+
+// Layer is layer image with opacity.
+type Layer struct {
+	Name    string
+	Image   image.Image
+	Opacity uint8
+	Hidden  bool
+}
+
+// Compose returns image composed of visible layers, visibility of toggled layers is inverted.
+// Layers are blended in normal mode only.
+func (l *Layers) Compose(toggled map[int]bool) image.Image {
+	dst := image.NewRGBA(l.Rect)
+
+	for i, layer := range l.Layers {
+		if layer.Hidden != toggled[i] {
+			continue
+		}
+
+		mask := image.NewUniform(color.Alpha{layer.Opacity})
+		draw.DrawMask(dst, layer.Image.Bounds(), layer.Image, layer.Image.Bounds().Min, mask, image.ZP, draw.Over)
+	}
+
+	return dst
+}
+
+// add appends layers and layers in groups, group names are prefixed to names.
+func (l *Layers) add(layers []psd.Layer, prefix string, hidden bool, opacity int) {
+	for i := range layers {
+		layer := &layers[i]
+
+		name := prefix + layer.Name
+		h := hidden || !layer.Visible()
+		o := opacity * int(layer.Opacity) / 255
+
+		if len(layer.Layer) > 0 {
+			l.add(layer.Layer, name+"/", h, o)
+			continue
+		}
+
+		if layer.Picker == nil || layer.Rect.Empty() {
+			continue
+		}
+
+		l.Layers = append(l.Layers, Layer{name, layer.Picker, uint8(o), h})
+	}
+}
+
+// decodePSD decodes PSD with layers, it returns nil if data is not PSD.
+func decodePSD(ctx context.Context, data []byte) (image.Image, error) {
+	if !bytes.HasPrefix(data, []byte("8BPS")) {
+		return nil, nil
+	}
+
+	p, _, err := psd.Decode(newReader(ctx, bytes.NewReader(data), int64(len(data)), nil), &psd.DecodeOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Layers{}
+	l.Rect = p.Config.Rect
+	l.add(p.Layer, "", false, 255)
+
+	if len(l.Layers) == 0 {
+		return p.Picker, nil
+	}
+
+	l.Image = p.Picker
+	if l.Image == nil {
+		l.Image = l.Compose(nil)
+	}
+
+	return l, nil
+}
+
+// SelectLayer selects layer of current image n layers away, it wraps around.
+func (v *Viewer) SelectLayer(n int) {
+	l, ok := v.orig.(*Layers)
+	if !ok {
+		return
+	}
+
+	count := len(l.Layers)
+	v.layer = ((v.layer+n)%count + count) % count
+
+	v.status()
+}
+
+// ToggleLayer shows or hides selected layer of current image, it is kept while navigating.
+func (v *Viewer) ToggleLayer() {
+	l, ok := v.orig.(*Layers)
+	if !ok || v.layer > len(l.Layers)-1 {
+		return
+	}
+
+	toggled := v.layers[v.Current()]
+	if toggled == nil {
+		toggled = make(map[int]bool)
+		v.layers[v.Current()] = toggled
+	}
+
+	if toggled[v.layer] {
+		delete(toggled, v.layer)
+	} else {
+		toggled[v.layer] = true
+	}
+
+	v.img = transform(v.source(), v.orientations[v.Current()])
+
+	v.redraw()
+}
+
+// layerTitle returns selected layer for title.
+func (v *Viewer) layerTitle() string {
+	l, ok := v.orig.(*Layers)
+	if !ok || v.layer > len(l.Layers)-1 {
+		return ""
+	}
+
+	layer := l.Layers[v.layer]
+
+	s := fmt.Sprintf("layer %d/%d %s", v.layer+1, len(l.Layers), layer.Name)
+	if layer.Hidden != v.layers[v.Current()][v.layer] {
+		s += " hidden"
+	}
+
+	return s
+}
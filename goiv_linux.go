@@ -7,16 +7,16 @@ import (
 	"os"
 )
 
-func display(images []string, width, height int) {
+func display(images []string, opts *Options) {
 	if os.Getenv("DISPLAY") == "" {
-		err := displayDRM(images)
+		err := displayDRM(images, opts)
 		if err != nil {
-			e := displayFB(images)
+			e := displayFB(images, opts)
 			if e != nil {
 				fmt.Fprintf(os.Stderr, "%s; %s\n", err.Error(), e.Error())
 			}
 		}
 	} else {
-		displayX11(images, width, height)
+		displayX11(images, opts)
 	}
 }
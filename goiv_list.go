@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Entry is image from list, title and caption are from playlist.
+type Entry struct {
+	Path    string `json:"path"`
+	Title   string `json:"title"`
+	Caption string `json:"caption"`
+}
+
+// readList returns images in list from file name, or from stdin when name is empty.
+// List is JSON array of entries or paths, M3U playlist, or one path per line, or per NUL with nul set.
+// Relative paths in JSON and M3U are relative to directory of the list.
+func readList(r io.Reader, name string, nul bool) ([]Entry, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := ""
+	if name != "" {
+		dir = filepath.Dir(name)
+	}
+
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	head := bytes.TrimSpace(data)
+
+	if bytes.HasPrefix(head, []byte("[")) {
+		// plain list can start with [ too
+		if entries, err := jsonList(data, dir); err == nil {
+			return entries, nil
+		}
+	}
+
+	ext := strings.ToLower(filepath.Ext(name))
+	if bytes.HasPrefix(head, []byte("#EXTM3U")) || ext == ".m3u" || ext == ".m3u8" {
+		return m3uList(data, dir), nil
+	}
+
+	entries := make([]Entry, 0)
+	for _, p := range split(data, nul) {
+		entries = append(entries, Entry{Path: p})
+	}
+
+	return entries, nil
+}
+
+// split returns lines of data, or NUL separated names with nul set, empty names are skipped.
+func split(data []byte, nul bool) []string {
+	if !nul {
+		return lines(bytes.NewReader(data))
+	}
+
+	names := make([]string, 0)
+	for _, n := range bytes.Split(data, []byte{0}) {
+		if len(n) > 0 {
+			names = append(names, string(n))
+		}
+	}
+
+	return names
+}
+
+// jsonList parses JSON array of entries, or of paths.
+func jsonList(data []byte, dir string) ([]Entry, error) {
+	var items []json.RawMessage
+	err := json.Unmarshal(data, &items)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(items))
+	for _, item := range items {
+		var e Entry
+		if err := json.Unmarshal(item, &e.Path); err != nil {
+			if err := json.Unmarshal(item, &e); err != nil {
+				return nil, err
+			}
+		}
+
+		if e.Path != "" {
+			e.Path = listPath(e.Path, dir)
+			entries = append(entries, e)
+		}
+	}
+
+	return entries, nil
+}
+
+// m3uList parses M3U playlist, title of entry is from #EXTINF line before it.
+func m3uList(data []byte, dir string) []Entry {
+	entries := make([]Entry, 0)
+	title := ""
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			// #EXTINF:duration,title
+			if strings.HasPrefix(line, "#EXTINF:") {
+				title = ""
+				if i := strings.IndexByte(line, ','); i >= 0 {
+					title = strings.TrimSpace(line[i+1:])
+				}
+			}
+			continue
+		}
+
+		entries = append(entries, Entry{Path: listPath(line, dir), Title: title})
+		title = ""
+	}
+
+	return entries
+}
+
+// listPath returns path relative to directory of list, URLs and absolute paths are kept.
+func listPath(p, dir string) string {
+	if dir == "" || p == "-" || isURL(p) || filepath.IsAbs(p) {
+		return p
+	}
+
+	return filepath.Join(dir, p)
+}
+
+// addEntries appends paths of entries to args, titles and captions are kept in options.
+func (o *Options) addEntries(args []string, entries []Entry) []string {
+	for _, e := range entries {
+		args = append(args, e.Path)
+
+		if e.Title != "" || e.Caption != "" {
+			o.Entries[e.Path] = e
+		}
+	}
+
+	return args
+}
+
+// captionTitle returns title and caption of current image from list.
+func (v *Viewer) captionTitle() string {
+	e, ok := v.opts.Entries[v.Current()]
+	if !ok {
+		return ""
+	}
+
+	switch {
+	case e.Title != "" && e.Caption != "":
+		return e.Title + ": " + e.Caption
+	case e.Title != "":
+		return e.Title
+	}
+
+	return e.Caption
+}
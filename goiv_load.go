@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progressInterval is minimal interval between progress reports.
+const progressInterval = 100 * time.Millisecond
+
+// progressFunc reports number of bytes read and total size, total is -1 if unknown.
+type progressFunc func(n, total int64)
+
+// reader reads until context is done and reports progress.
+type reader struct {
+	ctx      context.Context
+	r        io.Reader
+	n        int64
+	total    int64
+	progress progressFunc
+	last     time.Time
+}
+
+// newReader returns reader that stops when context is done, progress can be nil.
+func newReader(ctx context.Context, r io.Reader, total int64, progress progressFunc) *reader {
+	return &reader{ctx: ctx, r: r, total: total, progress: progress}
+}
+
+// Read implements io.Reader.
+func (r *reader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+
+	if r.progress != nil && time.Since(r.last) >= progressInterval {
+		r.last = time.Now()
+		r.progress(r.n, r.total)
+	}
+
+	return n, err
+}
+
+// stdin holds image data from standard input, it can be read only once.
+var stdin struct {
+	once sync.Once
+	data []byte
+	err  error
+}
+
+// readData returns bytes from file, standard input (-), data URI, or URL downloaded with client.
+func readData(ctx context.Context, filename string, client *Client, progress progressFunc) ([]byte, error) {
+	if filename == "-" {
+		data, err := readStdin()
+		if err != nil {
+			return nil, fmt.Errorf("stdin: %s", err)
+		}
+
+		return data, nil
+	}
+
+	if strings.HasPrefix(filename, "data:") {
+		data, err := dataURI(filename)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", displayName(filename), err)
+		}
+
+		return data, nil
+	}
+
+	if p, ok := filePath(filename); ok {
+		filename = p
+	} else if isURL(filename) {
+		return client.Download(ctx, filename, progress)
+	}
+
+	data, err := readFile(ctx, filename)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	return data, nil
+}
+
+// readFile returns bytes from file.
+func readFile(ctx context.Context, filename string) ([]byte, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	return ioutil.ReadAll(newReader(ctx, file, -1, nil))
+}
+
+// readStdin returns bytes from standard input, it is read on first call unless data was already piped.
+func readStdin() ([]byte, error) {
+	stdin.once.Do(func() {
+		if stdin.data == nil {
+			stdin.data, stdin.err = ioutil.ReadAll(os.Stdin)
+		}
+	})
+
+	return stdin.data, stdin.err
+}
+
+// dataURI returns bytes of data URI, i.e. data:image/png;base64,iVBORw0KGgo...
+func dataURI(uri string) ([]byte, error) {
+	i := strings.IndexByte(uri, ',')
+	if i < 0 {
+		return nil, fmt.Errorf("missing comma")
+	}
+
+	meta, data := uri[len("data:"):i], uri[i+1:]
+
+	if !strings.HasSuffix(meta, ";base64") {
+		s, err := url.PathUnescape(data)
+		if err != nil {
+			return nil, err
+		}
+
+		return []byte(s), nil
+	}
+
+	// whitespace and missing padding are tolerated, URL-safe alphabet too
+	data = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\r', '\n':
+			return -1
+		case '-':
+			return '+'
+		case '_':
+			return '/'
+		}
+		return r
+	}, data)
+
+	return base64.RawStdEncoding.DecodeString(strings.TrimRight(data, "="))
+}
+
+// filePath returns local path of file URL, i.e. file:///tmp/a.png.
+func filePath(name string) (string, bool) {
+	if !strings.HasPrefix(name, "file://") {
+		return "", false
+	}
+
+	u, err := url.Parse(name)
+	if err != nil || (u.Host != "" && u.Host != "localhost") {
+		return "", false
+	}
+
+	// drive letter on Windows, i.e. file:///C:/a.png
+	p := u.Path
+	if len(p) >= 3 && p[0] == '/' && p[2] == ':' {
+		p = p[1:]
+	}
+
+	return filepath.FromSlash(p), true
+}
+
+// displayName returns name of image for title and messages, data URIs are shortened to media type.
+func displayName(name string) string {
+	if name == "-" {
+		return "stdin"
+	}
+
+	if strings.HasPrefix(name, "data:") {
+		if i := strings.IndexAny(name, ";,"); i >= 0 {
+			return fmt.Sprintf("%s (%d bytes)", name[:i], len(name))
+		}
+	}
+
+	return name
+}
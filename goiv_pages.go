@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/tiff"
+)
+
+// Page is page of multi-page image, i.e. TIFF or icon with several sizes.
+type Page struct {
+	image.Image
+
+	Number int
+	Count  int
+}
+
+// pageName returns name of virtual entry for page n of file, i.e. scan.tif#3, first page is the file itself.
+func pageName(filename string, n int) string {
+	if n <= 1 {
+		return filename
+	}
+
+	return fmt.Sprintf("%s#%d", filename, n)
+}
+
+// splitPage returns file and page number of virtual entry, page is 1 for other files.
+// Fragments of URLs and data URIs are not pages.
+func splitPage(name string) (string, int) {
+	i := strings.LastIndex(name, "#")
+	if i < 0 || isURL(name) {
+		return name, 1
+	}
+
+	n, err := strconv.Atoi(name[i+1:])
+	if err != nil || n < 1 {
+		return name, 1
+	}
+
+	// file with # in name
+	if _, err := os.Stat(name); err == nil {
+		return name, 1
+	}
+
+	return name[:i], n
+}
+
+// decodeTIFFPage decodes page of TIFF, it returns nil if data is not TIFF or it has only one page.
+// Orientation of the page is applied when exif is set.
+func decodeTIFFPage(data []byte, page int, exif bool) (*Page, error) {
+	pages := tiffPages(data)
+	if len(pages) == 0 || (len(pages) == 1 && page == 1) {
+		return nil, nil
+	}
+
+	if page > len(pages) {
+		return nil, fmt.Errorf("page %d of %d", page, len(pages))
+	}
+
+	// decoder reads only first IFD, so header is pointed to IFD of the page
+	d := append([]byte{}, data...)
+	order := tiffOrder(d)
+	off := pages[page-1]
+	order.PutUint32(d[4:], uint32(off))
+
+	img, err := tiff.Decode(bytes.NewReader(d))
+	if err != nil {
+		return nil, err
+	}
+
+	if exif {
+		if v, ok := ifdTag(d, order, off, 0x0112); ok {
+			img = transform(img, exifOrientations[uint16(v)])
+		}
+	}
+
+	return &Page{withProfile(img, d), page, len(pages)}, nil
+}
+
+// tiffPages returns offsets of IFDs of TIFF pages, reduced resolution images are skipped.
+func tiffPages(tiff []byte) []int {
+	order := tiffOrder(tiff)
+	if order == nil || (string(tiff[:4]) != "II*\x00" && string(tiff[:4]) != "MM\x00*") {
+		return nil
+	}
+
+	pages := make([]int, 0)
+	seen := make(map[int]bool)
+
+	for off := int(order.Uint32(tiff[4:])); off >= 8 && off+2 <= len(tiff) && !seen[off]; {
+		seen[off] = true
+
+		if t, ok := ifdTag(tiff, order, off, 0x00fe); !ok || t&1 == 0 {
+			pages = append(pages, off)
+		}
+
+		next := off + 2 + int(order.Uint16(tiff[off:]))*12
+		if next+4 > len(tiff) {
+			break
+		}
+
+		off = int(order.Uint32(tiff[next:]))
+	}
+
+	return pages
+}
+
+// Page goes to page of current multi-page image n pages away.
+func (v *Viewer) Page(n int) {
+	p, ok := v.orig.(*Page)
+	if !ok {
+		return
+	}
+
+	next := p.Number + n
+	if next < 1 || next > p.Count {
+		return
+	}
+
+	// list of images is kept, page is selected for the entry
+	v.pages[v.Current()] = next
+	v.zoom = 0
+	v.pan = image.ZP
+
+	err := v.Update()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+	}
+}
+
+// pageTitle returns page counter for title.
+func (v *Viewer) pageTitle() string {
+	p, ok := v.orig.(*Page)
+	if !ok {
+		return ""
+	}
+
+	return fmt.Sprintf("page %d/%d", p.Number, p.Count)
+}
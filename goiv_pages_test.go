@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSplitPage(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		page int
+	}{
+		{"scan.tif#3", "scan.tif", 3},
+		{"scan.tif", "scan.tif", 1},
+		{"scan.tif#x", "scan.tif#x", 1},
+		{"scan.tif#0", "scan.tif#0", 1},
+		{"http://example.com/a.png#3", "http://example.com/a.png#3", 1},
+		{"data:image/png;base64,AAAA#3", "data:image/png;base64,AAAA#3", 1},
+	}
+
+	for _, tt := range tests {
+		file, page := splitPage(tt.name)
+		if file != tt.file || page != tt.page {
+			t.Errorf("%s: got %s, %d, want %s, %d", tt.name, file, page, tt.file, tt.page)
+		}
+	}
+}
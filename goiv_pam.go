@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	image.RegisterFormat("pam", "P7\n", decodePAM, decodePAMConfig)
+}
+
+// pamHeader is header of PAM image.
+type pamHeader struct {
+	width    int
+	height   int
+	depth    int
+	maxval   int
+	tupltype string
+}
+
+// readPAMHeader reads PAM header up to ENDHDR line.
+func readPAMHeader(r *bufio.Reader) (*pamHeader, error) {
+	line, err := r.ReadString('\n')
+	if err != nil || strings.TrimSpace(line) != "P7" {
+		return nil, fmt.Errorf("pam: invalid format")
+	}
+
+	h := &pamHeader{}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("pam: %s", err.Error())
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		if fields[0] == "ENDHDR" {
+			break
+		}
+
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("pam: invalid header line %s", strings.TrimSpace(line))
+		}
+
+		var n int
+		switch fields[0] {
+		case "WIDTH", "HEIGHT", "DEPTH", "MAXVAL":
+			n, err = strconv.Atoi(fields[1])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("pam: invalid %s %s", fields[0], fields[1])
+			}
+		}
+
+		switch fields[0] {
+		case "WIDTH":
+			h.width = n
+		case "HEIGHT":
+			h.height = n
+		case "DEPTH":
+			h.depth = n
+		case "MAXVAL":
+			h.maxval = n
+		case "TUPLTYPE":
+			h.tupltype = strings.Join(fields[1:], " ")
+		}
+	}
+
+	if h.width == 0 || h.height == 0 || h.depth == 0 || h.depth > 4 || h.maxval == 0 || h.maxval > 65535 {
+		return nil, fmt.Errorf("pam: invalid header")
+	}
+
+	// samples are up to 2 bytes, image has 8 bytes per pixel
+	if !validSize(h.width, h.height, 8) {
+		return nil, fmt.Errorf("pam: invalid size %dx%d", h.width, h.height)
+	}
+
+	return h, nil
+}
+
+// alpha checks if the last channel is alpha.
+func (h *pamHeader) alpha() bool {
+	return strings.HasSuffix(h.tupltype, "_ALPHA") || h.depth == 4
+}
+
+// decodePAMConfig returns color model and size of PAM image.
+func decodePAMConfig(r io.Reader) (image.Config, error) {
+	h, err := readPAMHeader(bufio.NewReader(r))
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	return image.Config{ColorModel: color.NRGBA64Model, Width: h.width, Height: h.height}, nil
+}
+
+// decodePAM decodes PAM image, samples are scaled from maxval to 16 bits.
+func decodePAM(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+
+	h, err := readPAMHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	size := 1
+	if h.maxval > 255 {
+		size = 2
+	}
+
+	row := make([]byte, h.width*h.depth*size)
+	img := image.NewNRGBA64(image.Rect(0, 0, h.width, h.height))
+
+	// gray and color channels, alpha is the last one
+	channels := h.depth
+	if h.alpha() {
+		channels--
+	}
+
+	for y := 0; y < h.height; y++ {
+		if _, err := io.ReadFull(br, row); err != nil {
+			return nil, fmt.Errorf("pam: %s", err.Error())
+		}
+
+		for x := 0; x < h.width; x++ {
+			var s [4]uint16
+			for c := 0; c < h.depth; c++ {
+				i := (x*h.depth + c) * size
+
+				v := int(row[i])
+				if size == 2 {
+					v = v<<8 | int(row[i+1])
+				}
+
+				if v > h.maxval {
+					v = h.maxval
+				}
+
+				s[c] = uint16(v * 0xffff / h.maxval)
+			}
+
+			var c color.NRGBA64
+			switch channels {
+			case 1, 2:
+				c = color.NRGBA64{s[0], s[0], s[0], 0xffff}
+			default:
+				c = color.NRGBA64{s[0], s[1], s[2], 0xffff}
+			}
+
+			if h.alpha() {
+				c.A = s[h.depth-1]
+			}
+
+			img.SetNRGBA64(x, y, c)
+		}
+	}
+
+	return img, nil
+}
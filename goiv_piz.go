@@ -0,0 +1,461 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Sizes of Huffman tables of PIZ compression.
+const (
+	hufEncBits = 16
+	hufDecBits = 14
+	hufEncSize = 1<<hufEncBits + 1
+	hufDecSize = 1 << hufDecBits
+	hufDecMask = hufDecSize - 1
+)
+
+// unPIZ uncompresses PIZ compressed chunk, Huffman coded and wavelet transformed 16-bit values.
+func (h *exrHeader) unPIZ(data []byte, width, count, size int) ([]byte, error) {
+	le := binary.LittleEndian
+
+	if len(data) < 4 {
+		return nil, fmt.Errorf("exr: invalid piz data")
+	}
+
+	// bitmap of used values, values are mapped to their index in bitmap
+	minNonZero, maxNonZero := int(le.Uint16(data)), int(le.Uint16(data[2:]))
+	data = data[4:]
+
+	if maxNonZero >= 1<<13 {
+		return nil, fmt.Errorf("exr: invalid piz bitmap")
+	}
+
+	bitmap := make([]byte, 1<<13)
+	if minNonZero <= maxNonZero {
+		n := maxNonZero - minNonZero + 1
+		if len(data) < n {
+			return nil, fmt.Errorf("exr: invalid piz bitmap")
+		}
+
+		copy(bitmap[minNonZero:], data[:n])
+		data = data[n:]
+	}
+
+	lut := make([]uint16, 1<<16)
+	k := 0
+	for i := range lut {
+		if i == 0 || bitmap[i>>3]&(1<<uint(i&7)) != 0 {
+			lut[k] = uint16(i)
+			k++
+		}
+	}
+	maxValue := uint16(k - 1)
+
+	if len(data) < 4 {
+		return nil, fmt.Errorf("exr: invalid piz data")
+	}
+
+	length := int(le.Uint32(data))
+	data = data[4:]
+	if length < 0 || length > len(data) {
+		return nil, fmt.Errorf("exr: invalid piz data")
+	}
+
+	buf := make([]uint16, size/2)
+	if err := hufUncompress(data[:length], buf); err != nil {
+		return nil, err
+	}
+
+	// channels are stored one after another, samples of 32-bit channels as two 16-bit values
+	start := make([]int, len(h.channels))
+	n := 0
+	for i, c := range h.channels {
+		start[i] = n
+
+		words := c.size() / 2
+		for j := 0; j < words; j++ {
+			wav2Decode(buf[n+j:], width, words, count, width*words, maxValue)
+		}
+
+		n += width * count * words
+	}
+
+	for i, v := range buf {
+		buf[i] = lut[v]
+	}
+
+	out := make([]byte, size)
+	o := 0
+	for y := 0; y < count; y++ {
+		for i, c := range h.channels {
+			words := width * c.size() / 2
+			for _, v := range buf[start[i] : start[i]+words] {
+				le.PutUint16(out[o:], v)
+				o += 2
+			}
+			start[i] += words
+		}
+	}
+
+	return out, nil
+}
+
+// wav2Decode reverts 2D wavelet transform of nx by ny values, ox and oy are offsets of next value and line.
+func wav2Decode(in []uint16, nx, ox, ny, oy int, mx uint16) {
+	wdec := wdec16
+	if mx < 1<<14 {
+		wdec = wdec14
+	}
+
+	n := nx
+	if ny < n {
+		n = ny
+	}
+
+	p := 1
+	for p <= n {
+		p <<= 1
+	}
+	p >>= 1
+	p2 := p
+	p >>= 1
+
+	for p >= 1 {
+		py := 0
+		ey := oy * (ny - p2)
+		oy1, oy2 := oy*p, oy*p2
+		ox1, ox2 := ox*p, ox*p2
+
+		for ; py <= ey; py += oy2 {
+			px := py
+			ex := py + ox*(nx-p2)
+
+			for ; px <= ex; px += ox2 {
+				p01 := px + ox1
+				p10 := px + oy1
+				p11 := p10 + ox1
+
+				i00, i10 := wdec(in[px], in[p10])
+				i01, i11 := wdec(in[p01], in[p11])
+				in[px], in[p01] = wdec(i00, i01)
+				in[p10], in[p11] = wdec(i10, i11)
+			}
+
+			// odd column
+			if nx&p != 0 {
+				p10 := px + oy1
+				in[px], in[p10] = wdec(in[px], in[p10])
+			}
+		}
+
+		// odd line
+		if ny&p != 0 {
+			px := py
+			ex := py + ox*(nx-p2)
+
+			for ; px <= ex; px += ox2 {
+				p01 := px + ox1
+				in[px], in[p01] = wdec(in[px], in[p01])
+			}
+		}
+
+		p2 = p
+		p >>= 1
+	}
+}
+
+// wdec14 decodes low and high wavelet coefficients of 14-bit values.
+func wdec14(l, h uint16) (uint16, uint16) {
+	hi := int(int16(h))
+	ai := int(int16(l)) + (hi & 1) + (hi >> 1)
+
+	return uint16(int16(ai)), uint16(int16(ai - hi))
+}
+
+// wdec16 decodes low and high wavelet coefficients of 16-bit values, with modulo arithmetic.
+func wdec16(l, h uint16) (uint16, uint16) {
+	m, d := int(l), int(h)
+	b := (m - (d >> 1)) & 0xffff
+	a := (d + b - 0x8000) & 0xffff
+
+	return uint16(a), uint16(b)
+}
+
+// hufDec is entry of Huffman decoding table, short codes have length and symbol, long codes list of symbols.
+type hufDec struct {
+	len  int
+	lit  int
+	long []int
+}
+
+// hufUncompress decodes Huffman coded data to out.
+func hufUncompress(data []byte, out []uint16) error {
+	if len(data) == 0 {
+		if len(out) != 0 {
+			return fmt.Errorf("exr: unexpected end of piz data")
+		}
+		return nil
+	}
+
+	if len(data) < 20 {
+		return fmt.Errorf("exr: invalid huffman data")
+	}
+
+	le := binary.LittleEndian
+
+	im, iM := le.Uint32(data), le.Uint32(data[4:])
+	nBits := le.Uint32(data[12:])
+	if im >= hufEncSize || iM >= hufEncSize || im > iM {
+		return fmt.Errorf("exr: invalid huffman table size")
+	}
+
+	codes, data, err := hufUnpackEncTable(data[20:], int(im), int(iM))
+	if err != nil {
+		return err
+	}
+
+	if uint64(nBits) > 8*uint64(len(data)) {
+		return fmt.Errorf("exr: invalid huffman data")
+	}
+
+	dec, err := hufBuildDecTable(codes, int(im), int(iM))
+	if err != nil {
+		return err
+	}
+
+	return hufDecode(codes, dec, data, int(nBits), int(iM), out)
+}
+
+// hufUnpackEncTable reads code lengths of symbols im to iM and returns canonical codes, as length | code << 6.
+func hufUnpackEncTable(data []byte, im, iM int) ([]uint64, []byte, error) {
+	codes := make([]uint64, hufEncSize)
+
+	var c uint64
+	lc, p := 0, 0
+
+	bits := func(n int) (uint64, error) {
+		for lc < n {
+			if p >= len(data) {
+				return 0, fmt.Errorf("exr: unexpected end of huffman table")
+			}
+
+			c = c<<8 | uint64(data[p])
+			p++
+			lc += 8
+		}
+
+		lc -= n
+
+		return (c >> uint(lc)) & (1<<uint(n) - 1), nil
+	}
+
+	for ; im <= iM; im++ {
+		l, err := bits(6)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		codes[im] = l
+
+		// lengths 59 to 62 are short runs of zeros, 63 is followed by length of long run
+		run := 0
+		if l == 63 {
+			n, err := bits(8)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			run = int(n) + 6
+		} else if l >= 59 {
+			run = int(l) - 59 + 2
+		}
+
+		if run > 0 {
+			if im+run > iM+1 {
+				return nil, nil, fmt.Errorf("exr: invalid huffman table")
+			}
+
+			for ; run > 0; run-- {
+				codes[im] = 0
+				im++
+			}
+			im--
+		}
+	}
+
+	// canonical codes, starting with the longest
+	var n [59]uint64
+	for _, l := range codes {
+		n[l]++
+	}
+
+	var code uint64
+	for i := 58; i > 0; i-- {
+		next := (code + n[i]) >> 1
+		n[i] = code
+		code = next
+	}
+
+	for i, l := range codes {
+		if l > 0 {
+			codes[i] = l | n[l]<<6
+			n[l]++
+		}
+	}
+
+	return codes, data[p:], nil
+}
+
+// hufBuildDecTable builds decoding table for codes of symbols im to iM.
+func hufBuildDecTable(codes []uint64, im, iM int) ([]hufDec, error) {
+	dec := make([]hufDec, hufDecSize)
+
+	for ; im <= iM; im++ {
+		c := codes[im] >> 6
+		l := int(codes[im] & 63)
+
+		if c>>uint(l) != 0 {
+			return nil, fmt.Errorf("exr: invalid huffman table")
+		}
+
+		if l > hufDecBits {
+			d := &dec[c>>uint(l-hufDecBits)]
+			if d.len != 0 {
+				return nil, fmt.Errorf("exr: invalid huffman table")
+			}
+
+			d.long = append(d.long, im)
+		} else if l > 0 {
+			base := int(c << uint(hufDecBits-l))
+			for i := 0; i < 1<<uint(hufDecBits-l); i++ {
+				d := &dec[base+i]
+				if d.len != 0 || d.long != nil {
+					return nil, fmt.Errorf("exr: invalid huffman table")
+				}
+
+				d.len = l
+				d.lit = im
+			}
+		}
+	}
+
+	return dec, nil
+}
+
+// hufDecode decodes nBits of data to out, symbol rlc is followed by number of repeats of previous value.
+func hufDecode(codes []uint64, dec []hufDec, data []byte, nBits, rlc int, out []uint16) error {
+	var c uint64
+	lc, p, o := 0, 0, 0
+
+	end := (nBits + 7) / 8
+
+	next := func() error {
+		if p >= len(data) {
+			return fmt.Errorf("exr: unexpected end of huffman data")
+		}
+
+		c = c<<8 | uint64(data[p])
+		p++
+		lc += 8
+
+		return nil
+	}
+
+	emit := func(sym int) error {
+		if sym == rlc {
+			if lc < 8 {
+				if err := next(); err != nil {
+					return err
+				}
+			}
+
+			lc -= 8
+			n := int(uint8(c >> uint(lc)))
+
+			if o+n > len(out) || o < 1 {
+				return fmt.Errorf("exr: invalid huffman data")
+			}
+
+			for v := out[o-1]; n > 0; n-- {
+				out[o] = v
+				o++
+			}
+		} else if o < len(out) {
+			out[o] = uint16(sym)
+			o++
+		} else {
+			return fmt.Errorf("exr: invalid huffman data")
+		}
+
+		return nil
+	}
+
+	for p < end {
+		if err := next(); err != nil {
+			return err
+		}
+
+		for lc >= hufDecBits {
+			d := dec[(c>>uint(lc-hufDecBits))&hufDecMask]
+
+			if d.len != 0 {
+				lc -= d.len
+				if err := emit(d.lit); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if d.long == nil {
+				return fmt.Errorf("exr: invalid huffman code")
+			}
+
+			found := false
+			for _, sym := range d.long {
+				l := int(codes[sym] & 63)
+				for lc < l && p < end {
+					if err := next(); err != nil {
+						return err
+					}
+				}
+
+				if lc >= l && codes[sym]>>6 == (c>>uint(lc-l))&(1<<uint(l)-1) {
+					lc -= l
+					if err := emit(sym); err != nil {
+						return err
+					}
+
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				return fmt.Errorf("exr: invalid huffman code")
+			}
+		}
+	}
+
+	// remaining short codes, padding bits of the last byte are dropped
+	i := (8 - nBits) & 7
+	c >>= uint(i)
+	lc -= i
+
+	for lc > 0 {
+		d := dec[(c<<uint(hufDecBits-lc))&hufDecMask]
+		if d.len == 0 {
+			return fmt.Errorf("exr: invalid huffman code")
+		}
+
+		lc -= d.len
+		if err := emit(d.lit); err != nil {
+			return err
+		}
+	}
+
+	if o != len(out) {
+		return fmt.Errorf("exr: unexpected end of piz data")
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"time"
+)
+
+// Animation speed limits.
+const (
+	playbackSpeedMin = 0.125
+	playbackSpeedMax = 8
+)
+
+// playback holds animation playback state.
+type playback struct {
+	anim   *Animation
+	frame  int
+	loop   int
+	paused bool
+	speed  float64
+
+	seq   int
+	timer *time.Timer
+}
+
+// play starts playback of animation from first frame, nil stops playback.
+func (v *Viewer) play(a *Animation) {
+	v.halt()
+
+	v.playback.anim = a
+	v.playback.frame = 0
+	v.playback.loop = 0
+	v.playback.paused = !v.opts.Animate
+
+	v.tick()
+}
+
+// TogglePause pauses or resumes animation.
+func (v *Viewer) TogglePause() {
+	if v.playback.anim == nil {
+		return
+	}
+
+	v.playback.paused = !v.playback.paused
+
+	if v.playback.paused {
+		v.halt()
+	} else {
+		v.playback.loop = 0
+		v.tick()
+	}
+
+	v.status()
+}
+
+// StepFrame pauses animation and shows frame n frames away, it wraps around.
+func (v *Viewer) StepFrame(n int) {
+	if v.playback.anim == nil {
+		return
+	}
+
+	v.playback.paused = true
+	v.halt()
+
+	count := len(v.playback.anim.Frames)
+	v.frame(((v.playback.frame+n)%count + count) % count)
+}
+
+// PlaybackSpeed multiplies animation speed by factor.
+func (v *Viewer) PlaybackSpeed(factor float64) {
+	s := v.playback.speed * factor
+	if s < playbackSpeedMin {
+		s = playbackSpeedMin
+	} else if s > playbackSpeedMax {
+		s = playbackSpeedMax
+	}
+
+	v.playback.speed = s
+
+	// delay of current frame is changed too
+	v.tick()
+
+	v.status()
+}
+
+// playing checks if animation is being played.
+func (v *Viewer) playing() bool {
+	return v.playback.anim != nil && !v.playback.paused
+}
+
+// tick starts timer for next frame, previous timer is discarded.
+func (v *Viewer) tick() {
+	if !v.playing() {
+		return
+	}
+
+	v.halt()
+
+	seq := v.playback.seq
+	delay := time.Duration(float64(v.playback.anim.Delays[v.playback.frame]) / v.playback.speed)
+
+	v.playback.timer = time.AfterFunc(delay, func() {
+		v.backend.Synchronize(func() {
+			if seq == v.playback.seq && v.playing() {
+				v.nextFrame()
+			}
+		})
+	})
+}
+
+// halt stops timer for next frame.
+func (v *Viewer) halt() {
+	v.playback.seq++
+
+	if v.playback.timer != nil {
+		v.playback.timer.Stop()
+		v.playback.timer = nil
+	}
+}
+
+// nextFrame shows next frame, animation stops on last frame after its last loop.
+func (v *Viewer) nextFrame() {
+	next := v.playback.frame + 1
+
+	if next > len(v.playback.anim.Frames)-1 {
+		v.playback.loop++
+		if v.playback.anim.Loops > 0 && v.playback.loop >= v.playback.anim.Loops {
+			v.playback.paused = true
+			v.status()
+			return
+		}
+
+		next = 0
+	}
+
+	v.frame(next)
+	v.tick()
+}
+
+// frame shows frame of animation.
+func (v *Viewer) frame(i int) {
+	v.playback.frame = i
+	v.img = transform(v.source(), v.orientations[v.Current()])
+
+	v.redraw()
+}
+
+// source returns current image, current frame of animation, image composed of visible layers, tone mapped HDR image
+// or image converted to display profile, without orientation applied.
+func (v *Viewer) source() image.Image {
+	if v.playback.anim != nil {
+		return v.playback.anim.Frames[v.playback.frame]
+	}
+
+	if l, ok := v.orig.(*Layers); ok && len(v.layers[v.Current()]) > 0 {
+		return l.Compose(v.layers[v.Current()])
+	}
+
+	if h, ok := v.orig.(*HDR); ok {
+		return h.ToneMap(v.exposure, v.tonemap)
+	}
+
+	return manage(v.orig, v.displayProfile())
+}
+
+// playbackTitle returns frame counter for title.
+func (v *Viewer) playbackTitle() string {
+	if v.playback.anim == nil {
+		return ""
+	}
+
+	s := fmt.Sprintf("frame %d/%d", v.playback.frame+1, len(v.playback.anim.Frames))
+
+	if v.playback.anim.Truncated {
+		s += " truncated"
+	}
+
+	if v.playback.speed != 1 {
+		s += fmt.Sprintf(" x%g", v.playback.speed)
+	}
+
+	if v.playback.paused {
+		s += " paused"
+	}
+
+	return s
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+func init() {
+	image.RegisterFormat("qoi", "qoif", decodeQOI, decodeQOIConfig)
+}
+
+// qoiHeader reads QOI header.
+func qoiHeader(r io.Reader) (int, int, error) {
+	var h [14]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return 0, 0, err
+	}
+
+	if string(h[:4]) != "qoif" {
+		return 0, 0, fmt.Errorf("qoi: invalid format")
+	}
+
+	width := int(binary.BigEndian.Uint32(h[4:]))
+	height := int(binary.BigEndian.Uint32(h[8:]))
+	if !validSize(width, height, 4) {
+		return 0, 0, fmt.Errorf("qoi: invalid size %dx%d", width, height)
+	}
+
+	return width, height, nil
+}
+
+// decodeQOIConfig returns color model and size of QOI image.
+func decodeQOIConfig(r io.Reader) (image.Config, error) {
+	width, height, err := qoiHeader(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	return image.Config{ColorModel: color.NRGBAModel, Width: width, Height: height}, nil
+}
+
+// decodeQOI decodes QOI image.
+func decodeQOI(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+
+	width, height, err := qoiHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	var index [64][4]byte
+	px := [4]byte{0, 0, 0, 255}
+	run := 0
+
+	for i := 0; i < len(img.Pix); i += 4 {
+		if run > 0 {
+			run--
+		} else {
+			b, err := br.ReadByte()
+			if err != nil {
+				return nil, fmt.Errorf("qoi: %s", err.Error())
+			}
+
+			switch {
+			case b == 0xfe:
+				if _, err := io.ReadFull(br, px[:3]); err != nil {
+					return nil, fmt.Errorf("qoi: %s", err.Error())
+				}
+			case b == 0xff:
+				if _, err := io.ReadFull(br, px[:]); err != nil {
+					return nil, fmt.Errorf("qoi: %s", err.Error())
+				}
+			case b>>6 == 0:
+				px = index[b]
+			case b>>6 == 1:
+				px[0] += (b>>4)&3 - 2
+				px[1] += (b>>2)&3 - 2
+				px[2] += b&3 - 2
+			case b>>6 == 2:
+				b2, err := br.ReadByte()
+				if err != nil {
+					return nil, fmt.Errorf("qoi: %s", err.Error())
+				}
+
+				dg := b&0x3f - 32
+				px[0] += dg + (b2>>4)&0x0f - 8
+				px[1] += dg
+				px[2] += dg + b2&0x0f - 8
+			default:
+				run = int(b & 0x3f)
+			}
+
+			index[(int(px[0])*3+int(px[1])*5+int(px[2])*7+int(px[3])*11)%64] = px
+		}
+
+		copy(img.Pix[i:i+4], px[:])
+	}
+
+	return img, nil
+}
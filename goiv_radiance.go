@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	image.RegisterFormat("hdr", "#?RADIANCE", decodeRadiance, decodeRadianceConfig)
+	image.RegisterFormat("hdr", "#?RGBE", decodeRadiance, decodeRadianceConfig)
+}
+
+// radianceHeader is header of Radiance image.
+type radianceHeader struct {
+	width  int
+	height int
+	flip   bool
+	xyz    bool
+}
+
+// readRadianceHeader reads header and resolution line, it returns header and pixel data.
+func readRadianceHeader(data []byte) (*radianceHeader, []byte, error) {
+	h := &radianceHeader{}
+
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			return nil, nil, fmt.Errorf("hdr: missing resolution")
+		}
+
+		line := strings.TrimSpace(string(data[:i]))
+		data = data[i+1:]
+
+		switch {
+		case line == "FORMAT=32-bit_rle_xyze":
+			h.xyz = true
+		case strings.HasPrefix(line, "FORMAT=") && line != "FORMAT=32-bit_rle_rgbe":
+			return nil, nil, fmt.Errorf("hdr: unsupported %s", line)
+		case line == "":
+			// resolution follows empty line, standard orientation is -Y height +X width
+			i := bytes.IndexByte(data, '\n')
+			if i < 0 {
+				return nil, nil, fmt.Errorf("hdr: missing resolution")
+			}
+
+			f := strings.Fields(string(data[:i]))
+			if len(f) != 4 || (f[0] != "-Y" && f[0] != "+Y") || f[2] != "+X" {
+				return nil, nil, fmt.Errorf("hdr: unsupported resolution %s", strings.TrimSpace(string(data[:i])))
+			}
+
+			var err1, err2 error
+			h.height, err1 = strconv.Atoi(f[1])
+			h.width, err2 = strconv.Atoi(f[3])
+			if err1 != nil || err2 != nil || h.width > 1<<16 || h.height > 1<<16 || !validSize(h.width, h.height, 16) {
+				return nil, nil, fmt.Errorf("hdr: invalid size %sx%s", f[3], f[1])
+			}
+
+			h.flip = f[0] == "+Y"
+
+			return h, data[i+1:], nil
+		}
+	}
+}
+
+// decodeRadianceConfig returns color model and size of Radiance image.
+func decodeRadianceConfig(r io.Reader) (image.Config, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	h, _, err := readRadianceHeader(data)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	return image.Config{ColorModel: color.NRGBA64Model, Width: h.width, Height: h.height}, nil
+}
+
+// decodeRadiance decodes Radiance image.
+func decodeRadiance(r io.Reader) (image.Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeRadianceData(data)
+}
+
+// decodeRadianceData decodes Radiance image with flat, old or new run-length encoded scanlines.
+func decodeRadianceData(data []byte) (*HDR, error) {
+	h, data, err := readRadianceHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	img := NewHDR(image.Rect(0, 0, h.width, h.height))
+	line := make([]byte, h.width*4)
+
+	for y := 0; y < h.height; y++ {
+		data, err = readRadianceLine(data, line)
+		if err != nil {
+			return nil, err
+		}
+
+		row := y
+		if h.flip {
+			row = h.height - 1 - y
+		}
+
+		for x := 0; x < h.width; x++ {
+			p := line[x*4 : x*4+4]
+			i := img.PixOffset(x, row)
+
+			img.Pix[i+3] = 1
+			if p[3] == 0 {
+				continue
+			}
+
+			f := math.Ldexp(1, int(p[3])-(128+8))
+			c := [3]float64{float64(p[0]) * f, float64(p[1]) * f, float64(p[2]) * f}
+
+			if h.xyz {
+				c = [3]float64{
+					3.2404542*c[0] - 1.5371385*c[1] - 0.4985314*c[2],
+					-0.9692660*c[0] + 1.8760108*c[1] + 0.0415560*c[2],
+					0.0556434*c[0] - 0.2040259*c[1] + 1.0572252*c[2],
+				}
+			}
+
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2] = float32(c[0]), float32(c[1]), float32(c[2])
+		}
+	}
+
+	return img, nil
+}
+
+// readRadianceLine reads scanline of RGBE pixels into line, it returns remaining data.
+func readRadianceLine(data []byte, line []byte) ([]byte, error) {
+	width := len(line) / 4
+
+	// new run-length encoding has components in separate runs
+	if width >= 8 && width < 0x8000 && len(data) >= 4 && data[0] == 2 && data[1] == 2 && data[2]&0x80 == 0 {
+		if int(data[2])<<8|int(data[3]) != width {
+			return nil, fmt.Errorf("hdr: invalid scanline width")
+		}
+
+		data = data[4:]
+
+		for c := 0; c < 4; c++ {
+			for x := 0; x < width; {
+				if len(data) < 2 {
+					return nil, fmt.Errorf("hdr: unexpected end of data")
+				}
+
+				n := int(data[0])
+				if n > 128 {
+					n -= 128
+					if x+n > width {
+						return nil, fmt.Errorf("hdr: invalid run")
+					}
+
+					for ; n > 0; n-- {
+						line[x*4+c] = data[1]
+						x++
+					}
+
+					data = data[2:]
+				} else {
+					if n == 0 || x+n > width || len(data) < 1+n {
+						return nil, fmt.Errorf("hdr: invalid run")
+					}
+
+					for i := 0; i < n; i++ {
+						line[x*4+c] = data[1+i]
+						x++
+					}
+
+					data = data[1+n:]
+				}
+			}
+		}
+
+		return data, nil
+	}
+
+	// flat pixels, old run-length encoding repeats previous pixel with 1, 1, 1, count
+	shift := uint(0)
+	for x := 0; x < width; {
+		if len(data) < 4 {
+			return nil, fmt.Errorf("hdr: unexpected end of data")
+		}
+
+		p := data[:4]
+		data = data[4:]
+
+		if p[0] == 1 && p[1] == 1 && p[2] == 1 {
+			if x == 0 {
+				return nil, fmt.Errorf("hdr: invalid run")
+			}
+
+			n := int(p[3]) << shift
+			if x+n > width {
+				return nil, fmt.Errorf("hdr: invalid run")
+			}
+
+			for ; n > 0; n-- {
+				copy(line[x*4:x*4+4], line[x*4-4:x*4])
+				x++
+			}
+
+			shift += 8
+			continue
+		}
+
+		copy(line[x*4:x*4+4], p)
+		x++
+		shift = 0
+	}
+
+	return data, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+)
+
+// decodeRAW decodes the largest JPEG preview embedded in TIFF-based camera RAW (CR2, NEF, ARW, DNG, PEF, RW2),
+// it returns nil if data is not RAW. Sensor data is not demosaiced, orientation of RAW is applied when exif is set.
+func decodeRAW(ctx context.Context, data []byte, exif bool) (image.Image, error) {
+	preview, ok := rawPreview(data)
+	if !ok {
+		return nil, nil
+	}
+
+	if preview == nil {
+		return nil, fmt.Errorf("raw: no embedded preview")
+	}
+
+	img, err := jpeg.Decode(newReader(ctx, bytes.NewReader(preview), int64(len(preview)), nil))
+	if err != nil {
+		return nil, err
+	}
+
+	// orientation is in IFD0, header of RW2 is not recognized as TIFF by exifTIFF
+	if exif {
+		if v, ok := tiffTag(data, 0x0112); ok {
+			img = transform(img, exifOrientations[uint16(v)])
+		}
+	}
+
+	return withProfile(img, preview), nil
+}
+
+// rawPreview returns the largest JPEG preview in RAW, ok is false if data is not RAW.
+// Previews are searched in all IFDs and SubIFDs, lossless JPEG with sensor data is skipped.
+func rawPreview(data []byte) (preview []byte, ok bool) {
+	rw2 := bytes.HasPrefix(data, []byte("IIU\x00"))
+	if !rw2 && !bytes.HasPrefix(data, []byte("II*\x00")) && !bytes.HasPrefix(data, []byte("MM\x00*")) {
+		return nil, false
+	}
+
+	order := tiffOrder(data)
+	if order == nil {
+		return nil, false
+	}
+
+	ifds := rawIFDs(data, order)
+
+	// CR2 is marked in header, other formats have sensor data in CFA or linear raw IFD, DNG has version tag
+	ok = rw2 || (len(data) >= 10 && string(data[8:10]) == "CR")
+	for _, off := range ifds {
+		if p, found := ifdTag(data, order, off, 0x0106); found && (p == 32803 || p == 34892) {
+			ok = true
+		}
+
+		if ifdEntry(data, order, off, 0xc612) != nil {
+			ok = true
+		}
+	}
+
+	if !ok {
+		return nil, false
+	}
+
+	best := 0
+	for _, off := range ifds {
+		candidates := make([][]byte, 0)
+
+		// JPEGInterchangeFormat, previews of CR2, NEF, ARW and PEF
+		if c := rawSlice(data, order, off, 0x0201, 0x0202); c != nil {
+			candidates = append(candidates, c)
+		}
+
+		// JPEG compressed single strip, previews of CR2 and DNG
+		if c, _ := ifdTag(data, order, off, 0x0103); c == 6 || c == 7 {
+			if s := rawSlice(data, order, off, 0x0111, 0x0117); s != nil {
+				candidates = append(candidates, s)
+			}
+		}
+
+		// JpegFromRaw of RW2
+		if rw2 {
+			if c := ifdBytes(data, order, off, 0x002e); c != nil {
+				candidates = append(candidates, c)
+			}
+		}
+
+		for _, c := range candidates {
+			if size := jpegSize(c); size > best {
+				preview, best = c, size
+			}
+		}
+	}
+
+	return preview, true
+}
+
+// rawIFDs returns offsets of IFDs in chain from header and their SubIFDs.
+func rawIFDs(data []byte, order binary.ByteOrder) []int {
+	ifds := make([]int, 0)
+	seen := make(map[int]bool)
+
+	var walk func(off int, depth int)
+	walk = func(off int, depth int) {
+		for off >= 8 && off+2 <= len(data) && !seen[off] && depth < 4 {
+			seen[off] = true
+			ifds = append(ifds, off)
+
+			for _, sub := range ifdLongs(data, order, off, 0x014a) {
+				walk(sub, depth+1)
+			}
+
+			next := off + 2 + int(order.Uint16(data[off:]))*12
+			if next+4 > len(data) {
+				break
+			}
+
+			off = int(order.Uint32(data[next:]))
+		}
+	}
+
+	walk(int(order.Uint32(data[4:])), 0)
+
+	return ifds
+}
+
+// rawSlice returns data at offset in tag with length in tag of IFD, when both have one value.
+func rawSlice(data []byte, order binary.ByteOrder, ifd int, offsetTag, lengthTag uint16) []byte {
+	for _, tag := range []uint16{offsetTag, lengthTag} {
+		e := ifdEntry(data, order, ifd, tag)
+		if e == nil || order.Uint32(e[4:]) != 1 {
+			return nil
+		}
+	}
+
+	off, ok := ifdTag(data, order, ifd, offsetTag)
+	if !ok {
+		return nil
+	}
+
+	n, ok := ifdTag(data, order, ifd, lengthTag)
+	if !ok || int64(off)+int64(n) > int64(len(data)) {
+		return nil
+	}
+
+	return data[off : off+n]
+}
+
+// jpegSize returns number of pixels of baseline or progressive JPEG, or 0 for other data, i.e. lossless JPEG.
+func jpegSize(data []byte) int {
+	for _, m := range []byte{0xc0, 0xc1, 0xc2} {
+		if s := jpegSegments(data, m); len(s) > 0 && len(s[0]) >= 6 {
+			be := binary.BigEndian
+			return int(be.Uint16(s[0][1:])) * int(be.Uint16(s[0][3:]))
+		}
+	}
+
+	return 0
+}
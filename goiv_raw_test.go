@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+// rw2 returns RW2 with JPEG preview of size and orientation in IFD0.
+func rw2(t *testing.T, width, height int, orientation uint16) []byte {
+	t.Helper()
+
+	var preview bytes.Buffer
+	if err := jpeg.Encode(&preview, image.NewGray(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	le := binary.LittleEndian
+	data := []byte("IIU\x00\x08\x00\x00\x00")
+
+	// IFD0 with JpegFromRaw and orientation entries, and next IFD offset
+	ifd := make([]byte, 2+2*12+4)
+	le.PutUint16(ifd, 2)
+
+	le.PutUint16(ifd[2:], 0x002e)
+	le.PutUint16(ifd[4:], 7)
+	le.PutUint32(ifd[6:], uint32(preview.Len()))
+	le.PutUint32(ifd[10:], uint32(len(data)+len(ifd)))
+
+	le.PutUint16(ifd[14:], 0x0112)
+	le.PutUint16(ifd[16:], 3)
+	le.PutUint32(ifd[18:], 1)
+	le.PutUint16(ifd[22:], orientation)
+
+	data = append(data, ifd...)
+
+	return append(data, preview.Bytes()...)
+}
+
+func TestDecodeRAWOrientation(t *testing.T) {
+	tests := []struct {
+		name        string
+		orientation uint16
+		exif        bool
+		size        image.Point
+	}{
+		{"normal", 1, true, image.Pt(16, 8)},
+		{"rotated", 6, true, image.Pt(8, 16)},
+		{"rotated without exif", 6, false, image.Pt(16, 8)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := decodeRAW(context.Background(), rw2(t, 16, 8, tt.orientation), tt.exif)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if img == nil {
+				t.Fatal("not decoded as RAW")
+			}
+
+			if got := img.Bounds().Size(); got != tt.size {
+				t.Errorf("got size %v, want %v", got, tt.size)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+)
+
+func init() {
+	image.RegisterFormat("sgi", "\x01\xda", decodeSGI, decodeSGIConfig)
+}
+
+// sgiHeader is header of SGI image.
+type sgiHeader struct {
+	rle      bool
+	bpc      int
+	width    int
+	height   int
+	channels int
+}
+
+// readSGIHeader reads SGI header.
+func readSGIHeader(data []byte) (*sgiHeader, error) {
+	if len(data) < 512 || data[0] != 0x01 || data[1] != 0xda {
+		return nil, fmt.Errorf("sgi: invalid format")
+	}
+
+	be := binary.BigEndian
+
+	h := &sgiHeader{
+		rle:      data[2] == 1,
+		bpc:      int(data[3]),
+		width:    int(be.Uint16(data[6:])),
+		height:   int(be.Uint16(data[8:])),
+		channels: int(be.Uint16(data[10:])),
+	}
+
+	// dimension 1 is a single row, 2 is a single channel
+	switch be.Uint16(data[4:]) {
+	case 1:
+		h.height, h.channels = 1, 1
+	case 2:
+		h.channels = 1
+	}
+
+	if h.bpc != 1 && h.bpc != 2 {
+		return nil, fmt.Errorf("sgi: invalid bytes per channel %d", h.bpc)
+	}
+
+	if !validSize(h.width, h.height, 8) || h.channels == 0 || h.channels > 4 {
+		return nil, fmt.Errorf("sgi: invalid size %dx%dx%d", h.width, h.height, h.channels)
+	}
+
+	return h, nil
+}
+
+// model returns color model of SGI image.
+func (h *sgiHeader) model() color.Model {
+	switch {
+	case h.channels == 1 && h.bpc == 1:
+		return color.GrayModel
+	case h.channels == 1:
+		return color.Gray16Model
+	case h.bpc == 1:
+		return color.NRGBAModel
+	}
+
+	return color.NRGBA64Model
+}
+
+// decodeSGIConfig returns color model and size of SGI image.
+func decodeSGIConfig(r io.Reader) (image.Config, error) {
+	data := make([]byte, 512)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return image.Config{}, err
+	}
+
+	h, err := readSGIHeader(data)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	return image.Config{ColorModel: h.model(), Width: h.width, Height: h.height}, nil
+}
+
+// decodeSGI decodes SGI image, channels are stored in planes of bottom-up rows.
+func decodeSGI(r io.Reader) (image.Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := readSGIHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if !h.rle && 512+h.width*h.height*h.channels*h.bpc > len(data) {
+		return nil, fmt.Errorf("sgi: unexpected end of data")
+	}
+
+	// planes of channels with rows in top-down order, samples are 16-bit
+	planes := make([][]uint16, h.channels)
+	for c := range planes {
+		planes[c] = make([]uint16, h.width*h.height)
+
+		for y := 0; y < h.height; y++ {
+			row := planes[c][(h.height-1-y)*h.width : (h.height-y)*h.width]
+
+			if err := h.readRow(data, row, y, c); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	rect := image.Rect(0, 0, h.width, h.height)
+
+	if h.channels == 1 {
+		if h.bpc == 1 {
+			img := image.NewGray(rect)
+			for i, v := range planes[0] {
+				img.Pix[i] = uint8(v)
+			}
+			return img, nil
+		}
+
+		img := image.NewGray16(rect)
+		for i, v := range planes[0] {
+			img.Pix[i*2], img.Pix[i*2+1] = uint8(v>>8), uint8(v)
+		}
+		return img, nil
+	}
+
+	// two channels are gray and alpha
+	sample := func(c, i int) uint16 {
+		switch {
+		case h.channels == 2 && c < 3:
+			return planes[0][i]
+		case h.channels == 2:
+			return planes[1][i]
+		case c < h.channels:
+			return planes[c][i]
+		case h.bpc == 1:
+			return 0xff
+		}
+		return 0xffff
+	}
+
+	if h.bpc == 1 {
+		img := image.NewNRGBA(rect)
+		for i := 0; i < h.width*h.height; i++ {
+			for c := 0; c < 4; c++ {
+				img.Pix[i*4+c] = uint8(sample(c, i))
+			}
+		}
+		return img, nil
+	}
+
+	img := image.NewNRGBA64(rect)
+	for i := 0; i < h.width*h.height; i++ {
+		for c := 0; c < 4; c++ {
+			v := sample(c, i)
+			img.Pix[i*8+c*2], img.Pix[i*8+c*2+1] = uint8(v>>8), uint8(v)
+		}
+	}
+
+	return img, nil
+}
+
+// readRow reads row y of channel c, rows are numbered from the bottom.
+func (h *sgiHeader) readRow(data []byte, row []uint16, y, c int) error {
+	be := binary.BigEndian
+
+	if !h.rle {
+		off := 512 + ((c*h.height+y)*h.width)*h.bpc
+		if off+h.width*h.bpc > len(data) {
+			return fmt.Errorf("sgi: unexpected end of data")
+		}
+
+		for x := range row {
+			if h.bpc == 1 {
+				row[x] = uint16(data[off+x])
+			} else {
+				row[x] = be.Uint16(data[off+x*2:])
+			}
+		}
+
+		return nil
+	}
+
+	// tables of offsets and lengths of rows follow the header
+	n := h.height * h.channels
+	i := c*h.height + y
+	if 512+n*8 > len(data) {
+		return fmt.Errorf("sgi: unexpected end of data")
+	}
+
+	off := int(be.Uint32(data[512+i*4:]))
+	length := int(be.Uint32(data[512+n*4+i*4:]))
+	if off < 0 || length < 0 || off+length > len(data) || off+length < off {
+		return fmt.Errorf("sgi: invalid row %d", y)
+	}
+
+	src := data[off : off+length]
+	read := func() (uint16, bool) {
+		if len(src) < h.bpc {
+			return 0, false
+		}
+
+		var v uint16
+		if h.bpc == 1 {
+			v = uint16(src[0])
+		} else {
+			v = be.Uint16(src)
+		}
+
+		src = src[h.bpc:]
+		return v, true
+	}
+
+	x := 0
+	for {
+		v, ok := read()
+		if !ok {
+			return fmt.Errorf("sgi: invalid row %d", y)
+		}
+
+		// low 7 bits are count, high bit tells literal run
+		count := int(v & 0x7f)
+		if count == 0 {
+			break
+		}
+
+		if x+count > len(row) {
+			return fmt.Errorf("sgi: invalid row %d", y)
+		}
+
+		if v&0x80 != 0 {
+			for ; count > 0; count-- {
+				if row[x], ok = read(); !ok {
+					return fmt.Errorf("sgi: invalid row %d", y)
+				}
+				x++
+			}
+		} else {
+			s, ok := read()
+			if !ok {
+				return fmt.Errorf("sgi: invalid row %d", y)
+			}
+
+			for ; count > 0; count-- {
+				row[x] = s
+				x++
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Slideshow interval limits and default.
+const (
+	slideshowMin     = 100 * time.Millisecond
+	slideshowMax     = time.Hour
+	slideshowDefault = 5 * time.Second
+)
+
+// slideshow holds slideshow state.
+type slideshow struct {
+	running  bool
+	interval time.Duration
+	loop     bool
+	shuffle  bool
+
+	// order is random order of images and pos is position of images in order, used with shuffle
+	order []int
+	pos   []int
+
+	seq   int
+	timer *time.Timer
+}
+
+// ToggleSlideshow starts or stops slideshow.
+func (v *Viewer) ToggleSlideshow() {
+	v.slides.running = !v.slides.running
+
+	if v.slides.running {
+		v.arm()
+	} else {
+		v.disarm()
+	}
+
+	v.status()
+}
+
+// SlideshowSpeed multiplies slideshow interval by factor.
+func (v *Viewer) SlideshowSpeed(factor float64) {
+	d := time.Duration(float64(v.slides.interval) * factor)
+	if d < slideshowMin {
+		d = slideshowMin
+	} else if d > slideshowMax {
+		d = slideshowMax
+	}
+
+	v.slides.interval = d.Round(slideshowMin)
+
+	if v.slides.running {
+		v.arm()
+	}
+
+	v.status()
+}
+
+// arm starts timer for next slide, previous timer is discarded.
+func (v *Viewer) arm() {
+	if !v.slides.running {
+		return
+	}
+
+	v.disarm()
+
+	seq := v.slides.seq
+	v.slides.timer = time.AfterFunc(v.slides.interval, func() {
+		v.backend.Synchronize(func() {
+			if seq == v.slides.seq && v.slides.running {
+				v.advance()
+			}
+		})
+	})
+}
+
+// disarm stops timer for next slide.
+func (v *Viewer) disarm() {
+	v.slides.seq++
+
+	if v.slides.timer != nil {
+		v.slides.timer.Stop()
+		v.slides.timer = nil
+	}
+}
+
+// advance goes to next slide, slideshow stops at the end unless it loops.
+func (v *Viewer) advance() {
+	next := v.idx + 1
+
+	if v.slides.shuffle {
+		if len(v.slides.order) != len(v.images) {
+			v.reshuffle()
+		}
+
+		p := v.slides.pos[v.idx] + 1
+		if p > len(v.images)-1 {
+			if !v.slides.loop {
+				v.ToggleSlideshow()
+				return
+			}
+
+			v.reshuffle()
+			p = 0
+		}
+
+		next = v.slides.order[p]
+	} else if next > len(v.images)-1 {
+		if !v.slides.loop {
+			v.ToggleSlideshow()
+			return
+		}
+
+		next = 0
+	}
+
+	if next == v.idx {
+		// single image, nothing to show
+		v.arm()
+		return
+	}
+
+	v.Jump(next)
+}
+
+// reshuffle creates new random order of images.
+func (v *Viewer) reshuffle() {
+	v.slides.order = rand.Perm(len(v.images))
+	v.slides.pos = make([]int, len(v.images))
+
+	for p, i := range v.slides.order {
+		v.slides.pos[i] = p
+	}
+}
+
+// slideshowTitle returns slideshow state for title.
+func (v *Viewer) slideshowTitle() string {
+	if !v.slides.running {
+		return ""
+	}
+
+	return fmt.Sprintf("slideshow %s", v.slides.interval)
+}
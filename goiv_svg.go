@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sync"
+
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+)
+
+// rasterizer is implemented by images that can be drawn at any size.
+type rasterizer interface {
+	// Rasterize draws image scaled to rectangle r of dst.
+	Rasterize(dst *image.RGBA, r image.Rectangle)
+}
+
+// Vector is SVG image, where single image is expected it is rasterized at its natural size.
+type Vector struct {
+	icon *oksvg.SvgIcon
+	o    Orientation
+
+	// mu guards icon transform and raster, it is shared by oriented copies
+	mu     *sync.Mutex
+	raster *image.RGBA
+}
+
+// decodeSVG decodes SVG, it returns nil if data is not SVG.
+func decodeSVG(data []byte) (*Vector, error) {
+	if sniff("", data) != "image/svg+xml" {
+		return nil, nil
+	}
+
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(data), oksvg.IgnoreErrorMode)
+	if err != nil {
+		return nil, err
+	}
+
+	if icon.ViewBox.W <= 0 || icon.ViewBox.H <= 0 {
+		return nil, fmt.Errorf("svg: missing size")
+	}
+
+	return &Vector{icon: icon, mu: &sync.Mutex{}}, nil
+}
+
+// Orient returns copy of image with orientation.
+func (v *Vector) Orient(o Orientation) *Vector {
+	return &Vector{icon: v.icon, o: o, mu: v.mu}
+}
+
+// ColorModel implements image.Image.
+func (v *Vector) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+// Bounds implements image.Image, size is size of view box.
+func (v *Vector) Bounds() image.Rectangle {
+	w := int(math.Max(1, math.Ceil(v.icon.ViewBox.W)))
+	h := int(math.Max(1, math.Ceil(v.icon.ViewBox.H)))
+
+	if v.o.Rotate%2 == 1 {
+		return image.Rect(0, 0, h, w)
+	}
+
+	return image.Rect(0, 0, w, h)
+}
+
+// At implements image.Image, image is rasterized at natural size on first use.
+func (v *Vector) At(x, y int) color.Color {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.raster == nil {
+		v.raster = image.NewRGBA(v.Bounds())
+		v.rasterize(v.raster, v.Bounds())
+	}
+
+	return v.raster.At(x, y)
+}
+
+// Rasterize draws image scaled to rectangle r of dst, only part inside dst is rasterized.
+func (v *Vector) Rasterize(dst *image.RGBA, r image.Rectangle) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.rasterize(dst, r)
+}
+
+// rasterize draws image, must be called with lock held.
+func (v *Vector) rasterize(dst *image.RGBA, r image.Rectangle) {
+	w, h := float64(r.Dx()), float64(r.Dy())
+	if v.o.Rotate%2 == 1 {
+		w, h = h, w
+	}
+
+	vb := v.icon.ViewBox
+	sx, sy := w/vb.W, h/vb.H
+
+	// view box to rectangle of size w, h at origin
+	m := rasterx.Matrix2D{A: sx, D: sy, E: -sx * vb.X, F: -sy * vb.Y}
+
+	if v.o.Flip {
+		m = multiply(rasterx.Matrix2D{A: -1, D: 1, E: w}, m)
+	}
+
+	switch v.o.Rotate {
+	case 1:
+		m = multiply(rasterx.Matrix2D{B: 1, C: -1, E: h}, m)
+	case 2:
+		m = multiply(rasterx.Matrix2D{A: -1, D: -1, E: w, F: h}, m)
+	case 3:
+		m = multiply(rasterx.Matrix2D{B: -1, C: 1, F: w}, m)
+	}
+
+	m = multiply(rasterx.Matrix2D{A: 1, D: 1, E: float64(r.Min.X), F: float64(r.Min.Y)}, m)
+
+	b := dst.Bounds()
+	scanner := rasterx.NewScannerGV(b.Dx(), b.Dy(), dst, b)
+	raster := rasterx.NewDasher(b.Dx(), b.Dy(), scanner)
+
+	v.icon.Transform = m
+	v.icon.Draw(raster, 1)
+}
+
+// multiply returns matrix a*b, transform b is applied first.
+func multiply(a, b rasterx.Matrix2D) rasterx.Matrix2D {
+	return rasterx.Matrix2D{
+		A: a.A*b.A + a.C*b.B,
+		B: a.B*b.A + a.D*b.B,
+		C: a.A*b.C + a.C*b.D,
+		D: a.B*b.C + a.D*b.D,
+		E: a.A*b.E + a.C*b.F + a.E,
+		F: a.B*b.E + a.D*b.F + a.F,
+	}
+}
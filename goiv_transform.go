@@ -0,0 +1,127 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+)
+
+// Orientation is image transform, horizontal flip followed by clockwise rotation in quarter turns.
+type Orientation struct {
+	Flip   bool
+	Rotate int
+}
+
+// RotateCW returns orientation rotated 90 degrees clockwise.
+func (o Orientation) RotateCW() Orientation {
+	return Orientation{o.Flip, (o.Rotate + 1) % 4}
+}
+
+// RotateCCW returns orientation rotated 90 degrees counter-clockwise.
+func (o Orientation) RotateCCW() Orientation {
+	return Orientation{o.Flip, (o.Rotate + 3) % 4}
+}
+
+// FlipHorizontal returns orientation flipped horizontally.
+func (o Orientation) FlipHorizontal() Orientation {
+	return Orientation{!o.Flip, (4 - o.Rotate) % 4}
+}
+
+// FlipVertical returns orientation flipped vertically.
+func (o Orientation) FlipVertical() Orientation {
+	return Orientation{!o.Flip, (6 - o.Rotate) % 4}
+}
+
+// String returns orientation description, empty for identity.
+func (o Orientation) String() string {
+	s := ""
+	if o.Flip {
+		s = "flip"
+	}
+
+	if o.Rotate != 0 {
+		if s != "" {
+			s += " "
+		}
+		s += []string{"", "90°", "180°", "270°"}[o.Rotate]
+	}
+
+	return s
+}
+
+// transform returns image with orientation applied.
+func transform(img image.Image, o Orientation) image.Image {
+	if o == (Orientation{}) {
+		return img
+	}
+
+	if v, ok := img.(*Vector); ok {
+		return v.Orient(o)
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	dw, dh := w, h
+	if o.Rotate%2 == 1 {
+		dw, dh = h, w
+	}
+
+	// images with more than 8 bits per channel are kept in 16 bits
+	var dst image.Image
+	var spix, dpix []uint8
+	var sstride, dstride, size int
+
+	if s, ok := img.(*image.CMYK); ok && b.Min == image.ZP {
+		// CMYK is kept to be converted with profile
+		d := image.NewCMYK(image.Rect(0, 0, dw, dh))
+		dst = d
+		spix, sstride, dpix, dstride, size = s.Pix, s.Stride, d.Pix, d.Stride, 4
+	} else if deep(img) {
+		s, ok := img.(*image.RGBA64)
+		if !ok || b.Min != image.ZP {
+			s = image.NewRGBA64(image.Rect(0, 0, w, h))
+			draw.Draw(s, s.Bounds(), img, b.Min, draw.Src)
+		}
+
+		d := image.NewRGBA64(image.Rect(0, 0, dw, dh))
+		dst = d
+		spix, sstride, dpix, dstride, size = s.Pix, s.Stride, d.Pix, d.Stride, 8
+	} else {
+		s, ok := img.(*image.RGBA)
+		if !ok || b.Min != image.ZP {
+			s = image.NewRGBA(image.Rect(0, 0, w, h))
+			draw.Draw(s, s.Bounds(), img, b.Min, draw.Src)
+		}
+
+		d := image.NewRGBA(image.Rect(0, 0, dw, dh))
+		dst = d
+		spix, sstride, dpix, dstride, size = s.Pix, s.Stride, d.Pix, d.Stride, 4
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sx := x
+			if o.Flip {
+				sx = w - 1 - x
+			}
+
+			var dx, dy int
+			switch o.Rotate {
+			case 0:
+				dx, dy = sx, y
+			case 1:
+				dx, dy = h-1-y, sx
+			case 2:
+				dx, dy = w-1-sx, h-1-y
+			case 3:
+				dx, dy = y, w-1-sx
+			}
+
+			si := y*sstride + x*size
+			di := dy*dstride + dx*size
+			copy(dpix[di:di+size], spix[si:si+size])
+		}
+	}
+
+	return dst
+}
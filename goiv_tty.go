@@ -0,0 +1,152 @@
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/term"
+)
+
+// ttyKeys maps terminal input sequences to key names.
+var ttyKeys = map[string]string{
+	"\x03":      "ctrl+c",
+	"\x1b":      "Escape",
+	"\r":        "Return",
+	"\n":        "ctrl+j",
+	" ":         "space",
+	"\x1b[A":    "Up",
+	"\x1b[B":    "Down",
+	"\x1b[C":    "Right",
+	"\x1b[D":    "Left",
+	"\x1b[1;5A": "ctrl+Up",
+	"\x1b[1;5B": "ctrl+Down",
+	"\x1b[1;5C": "ctrl+Right",
+	"\x1b[1;5D": "ctrl+Left",
+	"\x1b[H":    "Home",
+	"\x1b[F":    "End",
+	"\x1b[1~":   "Home",
+	"\x1b[4~":   "End",
+	"\x1b[5~":   "Page_Up",
+	"\x1b[6~":   "Page_Down",
+	"\x1b[5;5~": "ctrl+Page_Up",
+	"\x1b[6;5~": "ctrl+Page_Down",
+	"\x1b[15~":  "F5",
+	"\x1b[23~":  "F11",
+}
+
+// ttyKey returns key name for terminal input sequence.
+func ttyKey(b []byte) string {
+	if name, ok := ttyKeys[string(b)]; ok {
+		return name
+	}
+
+	if len(b) == 1 && b[0] > 32 && b[0] < 127 {
+		return string(b)
+	}
+
+	if len(b) == 1 && b[0] > 0 && b[0] <= 26 && b[0] != '\t' {
+		return "ctrl+" + string(rune('a'+b[0]-1))
+	}
+
+	if len(b) == 2 && b[0] == 27 {
+		if name := ttyKey(b[1:]); name != "" {
+			return "alt+" + name
+		}
+	}
+
+	return ""
+}
+
+// tty reads key presses from terminal in raw mode.
+type tty struct {
+	t     *term.Term
+	quit  bool
+	funcs chan func()
+	done  chan struct{} // closed when event loop returns
+}
+
+// openTTY opens /dev/tty and sets raw mode.
+func openTTY() (*tty, error) {
+	t, err := term.Open("/dev/tty")
+	if err != nil {
+		return nil, fmt.Errorf("Open: %s", err.Error())
+	}
+
+	err = t.SetRaw()
+	if err != nil {
+		t.Close()
+		return nil, fmt.Errorf("SetRaw: %s", err.Error())
+	}
+
+	return &tty{t: t, funcs: make(chan func()), done: make(chan struct{})}, nil
+}
+
+// Close restores terminal and closes it.
+func (t *tty) Close() {
+	t.t.Restore()
+	t.t.Close()
+}
+
+// Quit stops the event loop.
+func (t *tty) Quit() {
+	t.quit = true
+}
+
+// Fullscreen does nothing, console is always fullscreen.
+func (t *tty) Fullscreen() {
+}
+
+// Status does nothing, console has no title.
+func (t *tty) Status(title string) {
+}
+
+// Synchronize runs f on the event loop, f is dropped after quit.
+func (t *tty) Synchronize(f func()) {
+	select {
+	case t.funcs <- f:
+	case <-t.done:
+	}
+}
+
+// wait reads key presses and passes them to viewer until quit.
+func (t *tty) wait(v *Viewer) {
+	defer close(t.done)
+
+	keys := make(chan string)
+
+	go func() {
+		buf := make([]byte, 8)
+
+		for {
+			n, err := t.t.Read(buf)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+				close(keys)
+				return
+			}
+
+			if name := ttyKey(buf[0:n]); name != "" {
+				select {
+				case keys <- name:
+				case <-t.done:
+					return
+				}
+			}
+		}
+	}()
+
+	for !t.quit {
+		select {
+		case name, ok := <-keys:
+			if !ok {
+				return
+			}
+
+			v.Key(name)
+		case f := <-t.funcs:
+			f()
+		}
+	}
+}
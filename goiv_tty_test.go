@@ -0,0 +1,20 @@
+// +build linux
+
+package main
+
+import (
+	"testing"
+)
+
+func TestTTYKey(t *testing.T) {
+	for seq, want := range map[string]string{
+		"\x1b[1;5D": "ctrl+Left",
+		"\x1b[1;5C": "ctrl+Right",
+		"\x1b[1;5A": "ctrl+Up",
+		"\x1b[1;5B": "ctrl+Down",
+	} {
+		if got := ttyKey([]byte(seq)); got != want {
+			t.Errorf("%q: got %s, want %s", seq, got, want)
+		}
+	}
+}
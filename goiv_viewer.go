@@ -0,0 +1,842 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"os"
+	"sync"
+)
+
+// Action is a viewer command.
+type Action int
+
+// Viewer actions.
+const (
+	ActionNone Action = iota
+	ActionNext
+	ActionPrev
+	ActionSkip
+	ActionFirst
+	ActionLast
+	ActionFullscreen
+	ActionPrint
+	ActionQuit
+	ActionFit
+	ActionFitWidth
+	ActionFitHeight
+	ActionActual
+	ActionShrink
+	ActionZoomIn
+	ActionZoomOut
+	ActionPanLeft
+	ActionPanRight
+	ActionPanUp
+	ActionPanDown
+	ActionFilter
+	ActionRotateCW
+	ActionRotateCCW
+	ActionFlipHorizontal
+	ActionFlipVertical
+	ActionSlideshow
+	ActionSlideshowFaster
+	ActionSlideshowSlower
+	ActionPause
+	ActionFrameNext
+	ActionFramePrev
+	ActionPlaybackFaster
+	ActionPlaybackSlower
+	ActionPageNext
+	ActionPagePrev
+	ActionLayerNext
+	ActionLayerPrev
+	ActionLayerToggle
+	ActionExposureUp
+	ActionExposureDown
+	ActionExposureReset
+	ActionToneMap
+	ActionICC
+)
+
+// ViewMode is how image is scaled to viewport.
+type ViewMode int
+
+// View modes.
+const (
+	ViewFit ViewMode = iota
+	ViewFitWidth
+	ViewFitHeight
+	ViewActual
+	ViewShrink
+)
+
+// Filter is resampling filter used for scaling.
+type Filter int
+
+// Resampling filters, FilterAuto uses Lanczos3 for downscaling and bilinear for upscaling.
+const (
+	FilterAuto Filter = iota
+	FilterNearest
+	FilterBilinear
+	FilterBicubic
+	FilterMitchell
+	FilterLanczos2
+	FilterLanczos3
+)
+
+// prefetchWorkers is number of goroutines decoding images in background.
+const prefetchWorkers = 2
+
+// Zoom limits and step.
+const (
+	zoomMin  = 0.01
+	zoomMax  = 32
+	zoomStep = 1.25
+)
+
+// Command is action with optional count.
+type Command struct {
+	Action Action
+	Count  int
+}
+
+// Backend is implemented by display backends.
+type Backend interface {
+	// Size returns size of the drawable area.
+	Size() (width, height int)
+	// Draw draws frame and sets title.
+	Draw(img image.Image, title string) error
+	// Status sets title without drawing, i.e. while loading.
+	Status(title string)
+	// Synchronize runs f on the event loop, it is safe to call from other goroutines.
+	Synchronize(f func())
+	// Fullscreen toggles fullscreen.
+	Fullscreen()
+	// Quit stops the event loop.
+	Quit()
+}
+
+// Viewer holds list of images and current position, it is shared by all backends.
+type Viewer struct {
+	images []string
+	idx    int
+	opts   *Options
+
+	orig    image.Image
+	img     image.Image
+	backend Backend
+	keys    map[string]Command
+
+	mode ViewMode
+	zoom float64
+	pan  image.Point
+
+	filter  Filter
+	preview bool
+	gen     int
+
+	// exposure in stops and tone mapping operator of HDR images
+	exposure float64
+	tonemap  ToneMap
+
+	// icc is whether images with embedded profile are converted to display profile
+	icc bool
+
+	orientations map[string]Orientation
+
+	// pages are selected pages of multi-page images
+	pages map[string]int
+
+	// layers are toggled layers of images and layer is selected layer of current image
+	layers map[string]map[int]bool
+	layer  int
+
+	cache    *cache
+	prefetch *prefetcher
+
+	loading bool
+	seq     int
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+
+	slides   slideshow
+	playback playback
+}
+
+// NewViewer returns new viewer.
+func NewViewer(images []string, opts *Options, backend Backend) *Viewer {
+	v := &Viewer{}
+	v.images = images
+	v.opts = opts
+	if opts.Start < len(images) {
+		v.idx = opts.Start
+	}
+	v.backend = backend
+	v.keys = opts.Keys
+	v.mode = opts.Mode
+	v.filter = opts.Filter
+	v.preview = opts.Preview
+	v.exposure = opts.Exposure
+	v.tonemap = opts.ToneMap
+	v.icc = opts.ICC
+	v.orientations = make(map[string]Orientation)
+	v.pages = make(map[string]int)
+	v.layers = make(map[string]map[int]bool)
+	v.cache = newCache(opts.Cache)
+
+	if opts.Prefetch > 0 && opts.Cache > 0 {
+		v.prefetch = newPrefetcher(prefetchWorkers)
+	}
+
+	v.slides.interval = slideshowDefault
+	if opts.Slideshow > 0 {
+		v.slides.interval = opts.Slideshow
+		v.slides.running = true
+	}
+	v.slides.loop = opts.Loop
+	v.slides.shuffle = opts.Shuffle
+
+	v.playback.speed = 1
+
+	return v
+}
+
+// Key handles key press, name is X11 keysym name with optional ctrl+ and alt+ modifiers.
+func (v *Viewer) Key(name string) {
+	if c, ok := v.keys[name]; ok {
+		v.Do(c)
+	}
+}
+
+// Do executes command.
+func (v *Viewer) Do(c Command) {
+	switch c.Action {
+	case ActionNext:
+		v.Jump(v.idx + 1)
+	case ActionPrev:
+		v.Jump(v.idx - 1)
+	case ActionSkip:
+		v.Jump(v.idx + c.Count)
+	case ActionFirst:
+		v.Jump(0)
+	case ActionLast:
+		v.Jump(len(v.images) - 1)
+	case ActionFullscreen:
+		v.backend.Fullscreen()
+	case ActionPrint:
+		v.Print()
+	case ActionQuit:
+		if v.cancel != nil {
+			v.cancel()
+		}
+		v.disarm()
+		v.halt()
+		v.backend.Quit()
+	case ActionSlideshow:
+		v.ToggleSlideshow()
+	case ActionSlideshowFaster:
+		v.SlideshowSpeed(1 / 1.5)
+	case ActionSlideshowSlower:
+		v.SlideshowSpeed(1.5)
+	case ActionPause:
+		v.TogglePause()
+	case ActionFrameNext:
+		v.StepFrame(1)
+	case ActionFramePrev:
+		v.StepFrame(-1)
+	case ActionPlaybackFaster:
+		v.PlaybackSpeed(2)
+	case ActionPlaybackSlower:
+		v.PlaybackSpeed(0.5)
+	case ActionPageNext:
+		v.Page(1)
+	case ActionPagePrev:
+		v.Page(-1)
+	case ActionLayerNext:
+		v.SelectLayer(1)
+	case ActionLayerPrev:
+		v.SelectLayer(-1)
+	case ActionLayerToggle:
+		v.ToggleLayer()
+	case ActionExposureUp:
+		v.Expose(exposureStep)
+	case ActionExposureDown:
+		v.Expose(-exposureStep)
+	case ActionExposureReset:
+		v.Expose(0)
+	case ActionToneMap:
+		v.CycleToneMap()
+	case ActionICC:
+		v.ToggleICC()
+	case ActionFit:
+		v.SetMode(ViewFit)
+	case ActionFitWidth:
+		v.SetMode(ViewFitWidth)
+	case ActionFitHeight:
+		v.SetMode(ViewFitHeight)
+	case ActionActual:
+		v.SetMode(ViewActual)
+	case ActionShrink:
+		v.SetMode(ViewShrink)
+	case ActionZoomIn:
+		v.Zoom(zoomStep)
+	case ActionZoomOut:
+		v.Zoom(1 / zoomStep)
+	case ActionRotateCW:
+		v.Orient(v.orientations[v.Current()].RotateCW())
+	case ActionRotateCCW:
+		v.Orient(v.orientations[v.Current()].RotateCCW())
+	case ActionFlipHorizontal:
+		v.Orient(v.orientations[v.Current()].FlipHorizontal())
+	case ActionFlipVertical:
+		v.Orient(v.orientations[v.Current()].FlipVertical())
+	case ActionFilter:
+		v.filter = (v.filter + 1) % (FilterLanczos3 + 1)
+		v.redraw()
+	case ActionPanLeft, ActionPanRight, ActionPanUp, ActionPanDown:
+		width, height := v.backend.Size()
+		dx, dy := width/10, height/10
+		switch c.Action {
+		case ActionPanLeft:
+			v.Drag(dx, 0)
+		case ActionPanRight:
+			v.Drag(-dx, 0)
+		case ActionPanUp:
+			v.Drag(0, dy)
+		case ActionPanDown:
+			v.Drag(0, -dy)
+		}
+	}
+}
+
+// SetMode sets view mode and resets zoom and pan.
+func (v *Viewer) SetMode(mode ViewMode) {
+	v.mode = mode
+	v.zoom = 0
+	v.pan = image.ZP
+
+	v.redraw()
+}
+
+// Orient sets orientation of current image, it is kept while navigating.
+func (v *Viewer) Orient(o Orientation) {
+	v.orientations[v.Current()] = o
+
+	if v.orig == nil {
+		return
+	}
+
+	v.img = transform(v.source(), o)
+	v.zoom = 0
+	v.pan = image.ZP
+
+	v.redraw()
+}
+
+// Zoom multiplies current scale by factor.
+func (v *Viewer) Zoom(factor float64) {
+	if v.img == nil {
+		return
+	}
+
+	width, height := v.backend.Size()
+	old := v.scale(width, height)
+
+	v.zoom = math.Max(zoomMin, math.Min(zoomMax, old*factor))
+
+	// keep center of viewport in place
+	f := v.zoom / old
+	v.pan.X = int(float64(v.pan.X+width/2)*f) - width/2
+	v.pan.Y = int(float64(v.pan.Y+height/2)*f) - height/2
+
+	v.redraw()
+}
+
+// Drag moves image by dx, dy pixels when it is larger than viewport.
+func (v *Viewer) Drag(dx, dy int) {
+	if v.img == nil {
+		return
+	}
+
+	v.pan = v.pan.Sub(image.Pt(dx, dy))
+
+	v.redraw()
+}
+
+// Jump goes to image at index, indexes out of range are ignored.
+func (v *Viewer) Jump(idx int) {
+	if idx < 0 || idx > len(v.images)-1 || idx == v.idx {
+		return
+	}
+
+	v.idx = idx
+	v.zoom = 0
+	v.pan = image.ZP
+
+	err := v.Update()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+	}
+}
+
+// Print prints current image path to stdout.
+func (v *Viewer) Print() {
+	fmt.Fprintf(os.Stdout, "%s\n", v.images[v.idx])
+}
+
+// Index returns index of current image.
+func (v *Viewer) Index() int {
+	return v.idx
+}
+
+// Current returns path of current image.
+func (v *Viewer) Current() string {
+	return v.images[v.idx]
+}
+
+// Update decodes current image in background and draws it when done.
+// Loading of previous image is cancelled, title shows progress in the meantime.
+func (v *Viewer) Update() error {
+	if v.cancel != nil {
+		v.cancel()
+		v.cancel = nil
+	}
+
+	v.seq++
+	v.orig = nil
+	v.img = nil
+	v.layer = 0
+	v.play(nil)
+
+	filename, page := v.entry(v.idx)
+	key := "image:" + pageName(filename, page)
+
+	if img, ok := v.cache.Lookup(key); ok {
+		v.loading = false
+		v.arm()
+		return v.show(img)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	v.cancel = cancel
+	v.loading = true
+
+	seq := v.seq
+	title := v.Title()
+
+	v.backend.Status(title + " - loading…")
+
+	progress := func(n, total int64) {
+		status := fmt.Sprintf("%s - loading… %d KB", title, n>>10)
+		if total > 0 {
+			status = fmt.Sprintf("%s - loading… %d of %d KB", title, n>>10, total>>10)
+		}
+
+		v.backend.Synchronize(func() {
+			if seq == v.seq && v.loading {
+				v.backend.Status(status)
+			}
+		})
+	}
+
+	v.wg.Add(1)
+	go func() {
+		defer v.wg.Done()
+
+		load := func() (image.Image, error) {
+			img, err := decode(ctx, filename, page, v.opts, progress)
+			if err != nil && ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			return img, err
+		}
+
+		// retry if we joined load of the same image that was cancelled
+		var img image.Image
+		var err error
+		for {
+			img, err = v.cache.Get(key, load)
+			if err != context.Canceled || ctx.Err() != nil {
+				break
+			}
+		}
+
+		v.backend.Synchronize(func() {
+			if seq != v.seq {
+				return
+			}
+
+			v.loading = false
+			cancel()
+
+			// next slide is timed from when this one is shown
+			v.arm()
+
+			if err == nil {
+				err = v.show(img)
+			}
+
+			if err != nil {
+				v.backend.Status(title + " - " + err.Error())
+				fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			}
+		})
+	}()
+
+	return nil
+}
+
+// Wait waits for images being loaded and scaled in background, callbacks passed to Synchronize may still be pending.
+func (v *Viewer) Wait() {
+	v.wg.Wait()
+}
+
+// show sets decoded image as current and draws it, animation starts playing.
+func (v *Viewer) show(img image.Image) error {
+	v.orig = img
+
+	a, _ := img.(*Animation)
+	v.play(a)
+
+	v.img = transform(v.source(), v.orientations[v.Current()])
+
+	return v.draw()
+}
+
+// Resize draws current image scaled to new backend size, scaled images in cache are invalidated.
+func (v *Viewer) Resize() error {
+	v.cache.Purge("frame:")
+
+	if v.img == nil {
+		if v.loading {
+			return nil
+		}
+		return v.Update()
+	}
+
+	return v.draw()
+}
+
+// load returns decoded page of image from cache, or decodes it.
+func (v *Viewer) load(filename string, page int) (image.Image, error) {
+	return v.cache.Get("image:"+pageName(filename, page), func() (image.Image, error) {
+		return decode(context.Background(), filename, page, v.opts, nil)
+	})
+}
+
+// entry returns file and selected page of image at index, page of local file can be given in its name, i.e. scan.tif#3.
+func (v *Viewer) entry(i int) (string, int) {
+	filename, page := splitPage(v.images[i])
+	if n, ok := v.pages[v.images[i]]; ok {
+		page = n
+	}
+
+	return filename, page
+}
+
+// schedule queues decoding and scaling of next and previous images.
+func (v *Viewer) schedule() {
+	if v.prefetch == nil {
+		return
+	}
+
+	width, height := v.backend.Size()
+	mode, filter := v.mode, v.filter
+	exposure, tonemap, dither := v.exposure, v.tonemap, v.opts.Dither
+	profile := v.displayProfile()
+
+	jobs := make([]func(), 0)
+	for d := 1; d <= v.opts.Prefetch; d++ {
+		for _, i := range []int{v.idx + d, v.idx - d} {
+			if i < 0 || i > len(v.images)-1 {
+				continue
+			}
+
+			name := v.images[i]
+			if len(v.layers[name]) > 0 {
+				continue
+			}
+
+			filename, page := v.entry(i)
+
+			o := v.orientations[name]
+			key := frameKey(pageName(filename, page), o, width, height, mode, filter, exposure, tonemap, profile)
+
+			jobs = append(jobs, func() {
+				if _, ok := v.cache.Lookup(key); ok {
+					return
+				}
+
+				img, err := v.load(filename, page)
+				if err != nil {
+					return
+				}
+
+				// frames of animations are not cached
+				if _, ok := img.(*Animation); ok {
+					return
+				}
+
+				img = transform(manage(tone(img, exposure, tonemap), profile), o)
+
+				f, _, err := render(img, fitScale(img.Bounds(), mode, width, height), image.ZP, width, height, filter, dither)
+				if err == nil {
+					v.cache.Put(key, f)
+				}
+			})
+		}
+	}
+
+	v.prefetch.Set(jobs)
+}
+
+// frameKey returns cache key of rendered frame.
+func frameKey(filename string, o Orientation, width, height int, mode ViewMode, filter Filter, exposure float64, tonemap ToneMap, profile *Profile) string {
+	return fmt.Sprintf("frame:%dx%d:%d:%d:%v:%g:%d:%t:%s", width, height, mode, filter, o, exposure, tonemap, profile != nil, filename)
+}
+
+// Title returns title for current image.
+func (v *Viewer) Title() string {
+	if v.img == nil {
+		return fmt.Sprintf("%s [%d of %d] - %s", appName, v.idx+1, len(v.images), displayName(v.images[v.idx]))
+	}
+
+	width, height := v.backend.Size()
+
+	title := fmt.Sprintf("%s [%d of %d] - %s (%dx%d) %d%% %s", appName, v.idx+1, len(v.images),
+		displayName(v.images[v.idx]), v.img.Bounds().Dx(), v.img.Bounds().Dy(), int(v.scale(width, height)*100+0.5), filterNames[v.filter])
+
+	if o := v.orientations[v.Current()]; o != (Orientation{}) {
+		title += " " + o.String()
+	}
+
+	if s := v.pageTitle(); s != "" {
+		title += " " + s
+	}
+
+	if s := v.hdrTitle(); s != "" {
+		title += " " + s
+	}
+
+	if s := v.iccTitle(); s != "" {
+		title += " " + s
+	}
+
+	if s := v.layerTitle(); s != "" {
+		title += " " + s
+	}
+
+	if s := v.playbackTitle(); s != "" {
+		title += " " + s
+	}
+
+	if s := v.slideshowTitle(); s != "" {
+		title += " " + s
+	}
+
+	if s := v.captionTitle(); s != "" {
+		title += " - " + s
+	}
+
+	return title
+}
+
+// scale returns scale factor of current image for viewport size.
+func (v *Viewer) scale(width, height int) float64 {
+	if v.zoom != 0 {
+		return v.zoom
+	}
+
+	return fitScale(v.img.Bounds(), v.mode, width, height)
+}
+
+// fitScale returns scale factor of image with bounds b in view mode.
+func fitScale(b image.Rectangle, mode ViewMode, width, height int) float64 {
+	sx := float64(width) / float64(b.Dx())
+	sy := float64(height) / float64(b.Dy())
+
+	switch mode {
+	case ViewFitWidth:
+		return sx
+	case ViewFitHeight:
+		return sy
+	case ViewActual:
+		return 1
+	case ViewShrink:
+		return math.Min(1, math.Min(sx, sy))
+	}
+
+	return math.Min(sx, sy)
+}
+
+// status updates title of current image.
+func (v *Viewer) status() {
+	if v.img != nil {
+		v.backend.Status(v.Title())
+	}
+}
+
+// redraw draws current image and prints error.
+func (v *Viewer) redraw() {
+	if v.img == nil {
+		return
+	}
+
+	err := v.draw()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+	}
+}
+
+// draw renders current image and passes it to backend.
+// With preview enabled, nearest neighbour preview is drawn first and image is redrawn when scaled with filter.
+func (v *Viewer) draw() error {
+	width, height := v.backend.Size()
+	s := v.scale(width, height)
+
+	v.gen++
+	v.schedule()
+
+	// only frames in view mode without zoom and pan are cached, animations and images with toggled layers are not
+	key := ""
+	if v.zoom == 0 && v.pan == image.ZP && v.playback.anim == nil && len(v.layers[v.Current()]) == 0 {
+		filename, page := v.entry(v.idx)
+		key = frameKey(pageName(filename, page), v.orientations[v.Current()], width, height, v.mode, v.filter, v.exposure, v.tonemap, v.displayProfile())
+
+		if f, ok := v.cache.Lookup(key); ok {
+			return v.backend.Draw(f, v.Title())
+		}
+	}
+
+	_, vector := v.img.(rasterizer)
+
+	filter := v.filter
+	if v.preview && s != 1 && filter != FilterNearest && !v.playing() && !vector {
+		filter = FilterNearest
+	}
+
+	f, pan, err := render(v.img, s, v.pan, width, height, filter, v.opts.Dither)
+	if err != nil {
+		return err
+	}
+
+	v.pan = pan
+	title := v.Title()
+
+	if filter != v.filter {
+		gen, img, filter, dither := v.gen, v.img, v.filter, v.opts.Dither
+
+		v.wg.Add(1)
+		go func() {
+			defer v.wg.Done()
+
+			f, p, err := render(img, s, pan, width, height, filter, dither)
+			if err == nil && key != "" && p == image.ZP {
+				v.cache.Put(key, f)
+			}
+
+			v.backend.Synchronize(func() {
+				if gen != v.gen {
+					return
+				}
+
+				if err == nil {
+					err = v.backend.Draw(f, title)
+				}
+
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+				}
+			})
+		}()
+	} else if key != "" && pan == image.ZP {
+		v.cache.Put(key, f)
+	}
+
+	return v.backend.Draw(f, title)
+}
+
+// render returns black frame of given size with image scaled by s.
+// Image smaller than frame is centered, larger is offset by pan, which is clamped to image and returned.
+// With dither, images with more than 8 bits per channel are drawn in 16 bits and dithered.
+func render(img image.Image, s float64, pan image.Point, width, height int, filter Filter, dither bool) (*image.RGBA, image.Point, error) {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.Black, image.ZP, draw.Src)
+
+	b := img.Bounds()
+	sw := int(math.Max(1, float64(b.Dx())*s+0.5))
+	sh := int(math.Max(1, float64(b.Dy())*s+0.5))
+
+	var origin image.Point
+	origin.X, pan.X = offset(sw, width, pan.X)
+	origin.Y, pan.Y = offset(sh, height, pan.Y)
+
+	// vector images are rasterized at scale, not scaled
+	if r, ok := img.(rasterizer); ok {
+		r.Rasterize(dst, image.Rect(origin.X, origin.Y, origin.X+sw, origin.Y+sh))
+		return dst, pan, nil
+	}
+
+	// visible part of scaled image, in frame coordinates
+	vis := image.Rect(origin.X, origin.Y, origin.X+sw, origin.Y+sh).Intersect(dst.Bounds())
+	if vis.Empty() {
+		return dst, pan, nil
+	}
+
+	// source rectangle covering visible part
+	src := image.Rect(
+		b.Min.X+int(math.Floor(float64(vis.Min.X-origin.X)/s)),
+		b.Min.Y+int(math.Floor(float64(vis.Min.Y-origin.Y)/s)),
+		b.Min.X+int(math.Ceil(float64(vis.Max.X-origin.X)/s)),
+		b.Min.Y+int(math.Ceil(float64(vis.Max.Y-origin.Y)/s)),
+	).Intersect(b)
+
+	sub := img
+	if si, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		sub = si.SubImage(src)
+	} else {
+		src = b
+	}
+
+	i := sub
+	if s != 1 {
+		var err error
+		i, err = scale(sub, int(math.Max(1, float64(src.Dx())*s+0.5)), int(math.Max(1, float64(src.Dy())*s+0.5)), filter)
+		if err != nil {
+			return nil, pan, fmt.Errorf("scale: %s", err.Error())
+		}
+	}
+
+	at := origin.Add(image.Pt(int(float64(src.Min.X-b.Min.X)*s+0.5), int(float64(src.Min.Y-b.Min.Y)*s+0.5)))
+
+	if dither && deep(i) {
+		f := image.NewRGBA64(vis)
+		draw.Draw(f, vis, image.Black, image.ZP, draw.Src)
+		draw.Draw(f, vis, i, i.Bounds().Min.Add(vis.Min.Sub(at)), draw.Over)
+		ditherFrame(dst, f, vis)
+	} else {
+		draw.Draw(dst, vis, i, i.Bounds().Min.Add(vis.Min.Sub(at)), draw.Over)
+	}
+
+	return dst, pan, nil
+}
+
+// offset returns position of scaled image of size n in viewport of size size, and clamped pan.
+func offset(n, size, pan int) (int, int) {
+	if n <= size {
+		return (size - n) / 2, 0
+	}
+
+	if pan < 0 {
+		pan = 0
+	} else if pan > n-size {
+		pan = n - size
+	}
+
+	return -pan, pan
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeBackend records drawn titles, functions passed to Synchronize are queued until settle.
+type fakeBackend struct {
+	titles []string
+	quit   bool
+
+	mu    sync.Mutex
+	queue []func()
+}
+
+func (f *fakeBackend) Size() (int, int) {
+	return 64, 48
+}
+
+func (f *fakeBackend) Draw(img image.Image, title string) error {
+	f.titles = append(f.titles, title)
+	return nil
+}
+
+func (f *fakeBackend) Status(title string) {
+}
+
+func (f *fakeBackend) Synchronize(fn func()) {
+	f.mu.Lock()
+	f.queue = append(f.queue, fn)
+	f.mu.Unlock()
+}
+
+func (f *fakeBackend) Fullscreen() {
+}
+
+func (f *fakeBackend) Quit() {
+	f.quit = true
+}
+
+// settle waits for viewer and runs queued functions until there are none.
+func (f *fakeBackend) settle(v *Viewer) {
+	for {
+		v.Wait()
+
+		f.mu.Lock()
+		queue := f.queue
+		f.queue = nil
+		f.mu.Unlock()
+
+		if len(queue) == 0 {
+			return
+		}
+
+		for _, fn := range queue {
+			fn()
+		}
+	}
+}
+
+func TestViewerNavigation(t *testing.T) {
+	images := make([]string, 25)
+	for i := range images {
+		images[i] = filepath.Join("testdata", "golden", "source.png")
+	}
+
+	tests := []struct {
+		name  string
+		start int
+		keys  string
+		want  int
+	}{
+		{"next", 0, "j", 1},
+		{"next at last", 24, "Right", 24},
+		{"prev", 3, "k", 2},
+		{"prev at first", 0, "Left", 0},
+		{"next and prev", 0, "j space Page_Down k", 2},
+		{"skip forward", 2, "]", 12},
+		{"skip forward past last", 20, "]", 20},
+		{"skip backward", 12, "[", 2},
+		{"skip backward before first", 5, "[", 5},
+		{"skip both", 3, "] ] [", 13},
+		{"first", 7, ",", 0},
+		{"last", 7, ".", 24},
+		{"last and first", 7, ". ,", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := defaultOptions()
+			opts.Start = tt.start
+			opts.Prefetch = 0
+
+			b := &fakeBackend{}
+			v := NewViewer(images, opts, b)
+
+			if err := v.Update(); err != nil {
+				t.Fatal(err)
+			}
+			b.settle(v)
+
+			for _, key := range strings.Fields(tt.keys) {
+				v.Key(keyName(key))
+				b.settle(v)
+			}
+
+			if v.Index() != tt.want {
+				t.Errorf("got index %d, want %d", v.Index(), tt.want)
+			}
+
+			if len(b.titles) == 0 {
+				t.Fatal("nothing drawn")
+			}
+
+			title := b.titles[len(b.titles)-1]
+			if want := fmt.Sprintf("[%d of %d]", tt.want+1, len(images)); !strings.Contains(title, want) {
+				t.Errorf("got title %q, want %s", title, want)
+			}
+		})
+	}
+}
+
+func TestViewerQuit(t *testing.T) {
+	b := &fakeBackend{}
+	v := NewViewer([]string{filepath.Join("testdata", "golden", "source.png")}, defaultOptions(), b)
+
+	v.Key("q")
+
+	if !b.quit {
+		t.Error("backend not stopped")
+	}
+}
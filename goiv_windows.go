@@ -4,7 +4,7 @@ package main
 
 import (
 	"fmt"
-	"io/ioutil"
+	"image"
 	"os"
 
 	"github.com/lxn/walk"
@@ -13,75 +13,88 @@ import (
 
 //go:generate rsrc -manifest manifest/goiv.exe.manifest -o goiv_windows.syso
 
-func display(images []string, width, height int) {
+func display(images []string, opts *Options) {
 	mw := new(Window)
-	mw.images = images
+	v := NewViewer(images, opts, mw)
 
 	keyEvent := func(key walk.Key) {
-		switch key {
-		case walk.KeyQ, walk.KeyEscape:
-			mw.Close()
-		case walk.KeyPrior, walk.KeyLeft, walk.KeyK, walk.KeyLButton:
-			if mw.idx != 0 {
-				mw.idx -= 1
-				mw.drawImageError()
-			}
-		case walk.KeyNext, walk.KeyRight, walk.KeyJ, walk.KeyRButton, walk.KeySpace:
-			if mw.idx != len(mw.images)-1 {
-				mw.idx += 1
-				mw.drawImageError()
-			}
-		case walk.KeyF11, walk.KeyF:
-			mw.SetFullscreen(!mw.Fullscreen())
-		case walk.KeyOEM4:
-			if mw.idx-10 >= 0 {
-				mw.idx -= 10
-				mw.drawImageError()
-			}
-		case walk.KeyOEM6:
-			if mw.idx+10 <= len(mw.images)-1 {
-				mw.idx += 10
-				mw.drawImageError()
-			}
-		case walk.KeyOEMComma:
-			mw.idx = 0
-			mw.drawImageError()
-		case walk.KeyOEMPeriod:
-			mw.idx = len(mw.images) - 1
-			mw.drawImageError()
-		case walk.KeyReturn:
-			fmt.Fprintf(os.Stdout, "%s\n", mw.images[mw.idx])
+		if name := windowsKey(key); name != "" {
+			v.Key(name)
+		}
+	}
+
+	var drag image.Point
+	var dragged bool
+
+	mouseDown := func(x, y int, button walk.MouseButton) {
+		if button == walk.LeftButton {
+			drag = image.Pt(x, y)
+			dragged = false
 		}
 	}
 
-	mouseEvent := func(x, y int, button walk.MouseButton) {
+	mouseMove := func(x, y int, button walk.MouseButton) {
+		if button != walk.LeftButton {
+			return
+		}
+
+		p := image.Pt(x, y)
+		d := p.Sub(drag)
+		drag = p
+
+		if d != image.ZP {
+			dragged = true
+			v.Drag(d.X, d.Y)
+		}
+	}
+
+	mouseUp := func(x, y int, button walk.MouseButton) {
 		switch button {
-		case walk.RightButton:
-			if mw.idx != 0 {
-				mw.idx -= 1
-				mw.drawImageError()
-			}
 		case walk.LeftButton:
-			if mw.idx != len(mw.images)-1 {
-				mw.idx += 1
-				mw.drawImageError()
+			if !dragged {
+				v.Key("Button1")
 			}
+			dragged = false
+		case walk.RightButton:
+			v.Key("Button3")
+		}
+	}
+
+	mouseWheel := func(x, y int, button walk.MouseButton) {
+		delta := int16(uint32(button) >> 16)
+		if delta > 0 {
+			v.Key("Button4")
+		} else if delta < 0 {
+			v.Key("Button5")
+		}
+	}
+
+	sizeEvent := func() {
+		if mw.imageView == nil || !mw.drawn {
+			return
+		}
+
+		if err := v.Resize(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
 		}
 	}
 
 	if err := (decl.MainWindow{
-		AssignTo:    &mw.MainWindow,
-		OnKeyDown:   keyEvent,
-		OnMouseDown: mouseEvent,
-		MinSize:     decl.Size{320, 240},
-		Size:        decl.Size{width, height},
-		Layout:      decl.VBox{MarginsZero: true, SpacingZero: true},
+		AssignTo:  &mw.MainWindow,
+		OnKeyDown: keyEvent,
+		MinSize:   decl.Size{320, 240},
+		Size:      decl.Size{opts.Width, opts.Height},
+		Layout:    decl.VBox{MarginsZero: true, SpacingZero: true},
 		Children: []decl.Widget{
 			decl.ImageView{
-				AssignTo:    &mw.imageView,
-				Background:  decl.SolidColorBrush{Color: walk.RGB(0, 0, 0)},
-				OnKeyDown:   keyEvent,
-				OnMouseDown: mouseEvent,
+				AssignTo:      &mw.imageView,
+				Background:    decl.SolidColorBrush{Color: walk.RGB(0, 0, 0)},
+				Mode:          decl.ImageViewModeCenter,
+				OnKeyDown:     keyEvent,
+				OnMouseDown:   mouseDown,
+				OnMouseMove:   mouseMove,
+				OnMouseUp:     mouseUp,
+				OnSizeChanged: sizeEvent,
 			},
 		},
 	}.Create()); err != nil {
@@ -89,95 +102,138 @@ func display(images []string, width, height int) {
 		os.Exit(1)
 	}
 
-	mw.drawImageError()
+	mw.MouseWheel().Attach(mouseWheel)
+	mw.imageView.MouseWheel().Attach(mouseWheel)
+
+	if err := v.Update(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+	}
 
 	mw.Run()
 }
 
-type Window struct {
-	*walk.MainWindow
-
-	image     walk.Image
-	imageView *walk.ImageView
+// windowsKeys maps virtual keys to key names.
+var windowsKeys = map[walk.Key]string{
+	walk.KeyEscape:    "Escape",
+	walk.KeyReturn:    "Return",
+	walk.KeySpace:     "space",
+	walk.KeyPrior:     "Page_Up",
+	walk.KeyNext:      "Page_Down",
+	walk.KeyHome:      "Home",
+	walk.KeyEnd:       "End",
+	walk.KeyLeft:      "Left",
+	walk.KeyRight:     "Right",
+	walk.KeyUp:        "Up",
+	walk.KeyDown:      "Down",
+	walk.KeyF5:        "F5",
+	walk.KeyF11:       "F11",
+	walk.KeyOEM4:      "[",
+	walk.KeyOEM6:      "]",
+	walk.KeyOEMComma:  ",",
+	walk.KeyOEMPeriod: ".",
+	walk.KeyOEMPlus:   "=",
+	walk.KeyOEMMinus:  "-",
+	walk.KeyAdd:       "+",
+	walk.KeySubtract:  "-",
+}
 
-	idx    int
-	images []string
+// windowsShiftedKeys maps virtual keys to key names with shift, as on US keyboard.
+var windowsShiftedKeys = map[walk.Key]string{
+	walk.KeyOEM4:      "{",
+	walk.KeyOEM6:      "}",
+	walk.KeyOEMComma:  "<",
+	walk.KeyOEMPeriod: ">",
+	walk.KeyOEMPlus:   "+",
+	walk.KeyOEMMinus:  "_",
 }
 
-func (mw *Window) drawImageError() {
-	if err := mw.drawImage(); err != nil {
-		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+// windowsKey returns key name for virtual key, with modifiers.
+func windowsKey(key walk.Key) string {
+	name, ok := windowsShiftedKeys[key]
+	if !ok || !walk.ShiftDown() {
+		name, ok = windowsKeys[key]
 	}
-}
 
-func (mw *Window) drawImage() error {
-	var err error
+	if !ok {
+		switch {
+		case key >= walk.KeyA && key <= walk.KeyZ:
+			if walk.ShiftDown() {
+				name = string(rune(key))
+			} else {
+				name = string(rune(key - walk.KeyA + 'a'))
+			}
+		case key >= walk.Key0 && key <= walk.Key9:
+			name = string(rune(key))
+		default:
+			return ""
+		}
+	}
 
-	if mw.image != nil {
-		mw.image.Dispose()
-		mw.image = nil
+	if walk.AltDown() {
+		name = "alt+" + name
+	}
+	if walk.ControlDown() {
+		name = "ctrl+" + name
 	}
 
-	if isURL(mw.images[mw.idx]) {
-		b, err := downloadURL(mw.images[mw.idx])
-		if err != nil {
-			return err
-		}
+	return name
+}
 
-		tmp, err := ioutil.TempFile("", "goiv")
-		if err != nil {
-			return err
-		}
+type Window struct {
+	*walk.MainWindow
 
-		_, err = tmp.Write(b)
-		if err != nil {
-			return err
-		}
+	image     walk.Image
+	imageView *walk.ImageView
 
-		err = tmp.Close()
-		if err != nil {
-			return err
-		}
+	drawn bool
+}
 
-		defer os.Remove(tmp.Name())
+// Size returns size of image view.
+func (mw *Window) Size() (int, int) {
+	b := mw.imageView.ClientBoundsPixels()
+	return b.Width, b.Height
+}
 
-		mw.image, err = walk.NewImageFromFile(tmp.Name())
-		if err != nil {
-			return err
-		}
-	} else {
-		mw.image, err = walk.NewImageFromFile(mw.images[mw.idx])
-		if err != nil {
-			return err
-		}
-	}
+// Fullscreen toggles fullscreen.
+func (mw *Window) Fullscreen() {
+	mw.SetFullscreen(!mw.MainWindow.Fullscreen())
+}
 
-	var succeeded bool
-	defer func() {
-		if !succeeded {
-			if mw.image != nil {
-				mw.image.Dispose()
-			}
-		}
-	}()
+// Quit closes window.
+func (mw *Window) Quit() {
+	mw.Close()
+}
 
-	if mw.imageView == nil {
-		mw.imageView, err = walk.NewImageView(mw)
-		if err != nil {
-			return err
-		}
+// Status sets window title.
+func (mw *Window) Status(title string) {
+	mw.SetTitle(title)
+}
+
+// Synchronize runs f on the GUI thread.
+func (mw *Window) Synchronize(f func()) {
+	mw.MainWindow.Synchronize(f)
+}
+
+// Draw draws image in image view and sets title.
+func (mw *Window) Draw(img image.Image, title string) error {
+	bmp, err := walk.NewBitmapFromImage(img)
+	if err != nil {
+		return err
 	}
 
-	mw.imageView.SetMode(walk.ImageViewModeShrink)
-	if err = mw.imageView.SetImage(mw.image); err != nil {
+	if err = mw.imageView.SetImage(bmp); err != nil {
+		bmp.Dispose()
 		return err
 	}
 
-	title := fmt.Sprintf("%s [%d of %d] - %s (%dx%d)", appName, mw.idx+1, len(mw.images),
-		mw.images[mw.idx], mw.image.Size().Width, mw.image.Size().Height)
-	mw.SetTitle(title)
+	if mw.image != nil {
+		mw.image.Dispose()
+	}
 
-	succeeded = true
+	mw.image = bmp
+	mw.drawn = true
+
+	mw.SetTitle(title)
 
 	return nil
 }
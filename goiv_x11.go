@@ -8,6 +8,7 @@ import (
 	"image/draw"
 	"io/ioutil"
 	"os"
+	"sync"
 
 	"github.com/BurntSushi/xgb"
 	mshm "github.com/BurntSushi/xgb/shm"
@@ -18,19 +19,14 @@ import (
 	"github.com/BurntSushi/xgbutil/mousebind"
 	"github.com/BurntSushi/xgbutil/xevent"
 	"github.com/BurntSushi/xgbutil/xgraphics"
+	"github.com/BurntSushi/xgbutil/xprop"
+	"github.com/BurntSushi/xgbutil/xrect"
 	"github.com/BurntSushi/xgbutil/xwindow"
 	"github.com/gen2brain/shm"
 )
 
-const (
-	none = 1 << iota
-	loaded
-	scaled
-	drawn
-)
-
 // displayX11 displays images in X11 window.
-func displayX11(images []string, width, height int) {
+func displayX11(images []string, opts *Options) {
 	xgb.Logger.SetOutput(ioutil.Discard)
 	xgbutil.Logger.SetOutput(ioutil.Discard)
 
@@ -57,7 +53,7 @@ func displayX11(images []string, width, height int) {
 
 	defer win.Destroy()
 
-	win.Create(X.RootWin(), 0, 0, width, height, xproto.CwBackPixel, 0x000000)
+	win.Create(X.RootWin(), 0, 0, opts.Width, opts.Height, xproto.CwBackPixel, 0x000000)
 	win.Change(xproto.CwBackingStore, xproto.BackingStoreAlways)
 
 	win.WMGracefulClose(func(w *xwindow.Window) {
@@ -73,265 +69,301 @@ func displayX11(images []string, width, height int) {
 		fmt.Fprintf(os.Stderr, "WmWindowTypeSet: %s\n", err.Error())
 	}
 
-	win.Listen(xproto.EventMaskKeyPress, xproto.EventMaskButtonRelease, xproto.EventMaskStructureNotify, xproto.EventMaskExposure)
-
-	idx := 0
-	state := 0
+	win.Listen(xproto.EventMaskKeyPress, xproto.EventMaskButtonPress, xproto.EventMaskButtonRelease, xproto.EventMaskButton1Motion, xproto.EventMaskStructureNotify, xproto.EventMaskExposure)
 
 	rect, err := win.Geometry()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Geometry: %s\n", err.Error())
 	}
 
-	var shmId int
-	var seg mshm.Seg
-	var data []byte
-
-	var img image.Image
-	var ximg *xgraphics.Image
-
-	newImage := func() *xgraphics.Image {
-		var i *xgraphics.Image
-
-		if useShm {
-			shmSize := rect.Width() * rect.Height() * 4
+	wake, err := xprop.Atm(X, "_GOIV_WAKE")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Atm: %s\n", err.Error())
+	}
 
-			shmId, err = shm.Get(shm.IPC_PRIVATE, shmSize, shm.IPC_CREAT|0777)
+	// display profile is set on root window by color management tools, i.e. colord or dispwin
+	if opts.ICC && opts.Profile == nil {
+		if reply, err := xprop.GetProperty(X, X.RootWin(), "_ICC_PROFILE"); err == nil {
+			opts.Profile, err = parseDisplayProfile(reply.Value)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Get: %s\n", err.Error())
+				fmt.Fprintf(os.Stderr, "_ICC_PROFILE: %s\n", err.Error())
 			}
+		}
+	}
 
-			seg, err = mshm.NewSegId(X.Conn())
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "NewSegId: %s\n", err.Error())
-			}
+	w := &x11Window{X: X, win: win, rect: rect, useShm: useShm, wake: wake}
+	v := NewViewer(images, opts, w)
 
-			data, err = shm.At(shmId, 0, 0)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "At: %s\n", err.Error())
-			}
+	cbMsg := xevent.ClientMessageFun(func(xu *xgbutil.XUtil, e xevent.ClientMessageEvent) {
+		if e.Type == w.wake {
+			w.run()
+		}
+	})
+
+	cbKey := xevent.KeyPressFun(func(xu *xgbutil.XUtil, e xevent.KeyPressEvent) {
+		v.Key(x11Key(keybind.LookupString(xu, e.State, e.Detail), e.State))
+	})
 
-			mshm.Attach(X.Conn(), seg, uint32(shmId), false)
+	var drag image.Point
+	var dragged bool
 
-			i = &xgraphics.Image{
-				X:      X,
-				Pixmap: 0,
-				Pix:    data,
-				Stride: 4 * rect.Width(),
-				Rect:   image.Rect(0, 0, rect.Width(), rect.Height()),
-				Subimg: false,
-			}
-		} else {
-			i = xgraphics.New(X, image.Rect(0, 0, rect.Width(), rect.Height()))
+	cbPress := xevent.ButtonPressFun(func(xu *xgbutil.XUtil, e xevent.ButtonPressEvent) {
+		if e.Detail == 1 {
+			drag = image.Pt(int(e.EventX), int(e.EventY))
+			dragged = false
 		}
+	})
 
-		return i
-	}
+	cbMotion := xevent.MotionNotifyFun(func(xu *xgbutil.XUtil, e xevent.MotionNotifyEvent) {
+		p := image.Pt(int(e.EventX), int(e.EventY))
+		d := p.Sub(drag)
+		drag = p
 
-	ximg = newImage()
+		if d != image.ZP {
+			dragged = true
+			v.Drag(d.X, d.Y)
+		}
+	})
 
-	loadImage := func() {
-		img, err = decode(images[idx], rect.Width(), rect.Height())
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+	cbBut := xevent.ButtonReleaseFun(func(xu *xgbutil.XUtil, e xevent.ButtonReleaseEvent) {
+		if e.Detail == 1 && dragged {
+			dragged = false
 			return
 		}
 
-		state |= loaded
-	}
+		v.Key(fmt.Sprintf("Button%d", e.Detail))
+	})
 
-	scaleImage := func() {
-		if ximg != nil {
-			ximg.Destroy()
-			ximg = nil
-		}
+	cbCfg := xevent.ConfigureNotifyFun(func(xu *xgbutil.XUtil, e xevent.ConfigureNotifyEvent) {
+		if w.rect.Width() != int(e.Width) || w.rect.Height() != int(e.Height) {
+			w.rect, err = win.Geometry()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Geometry: %s\n", err.Error())
+			}
 
-		if useShm {
-			mshm.Detach(X.Conn(), seg)
-			shm.Rm(shmId)
-			shm.Dt(data)
+			err = v.Resize()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			}
 		}
+	})
 
-		ximg = newImage()
-
-		i, err := scale(img, rect.Width(), rect.Height())
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "scale: %s\n", err.Error())
+	cbExp := xevent.ExposeFun(func(xu *xgbutil.XUtil, e xevent.ExposeEvent) {
+		if e.ExposeEvent.Count == 0 && w.ximg != nil {
+			w.ximg.XExpPaint(win.Id, 0, 0)
 		}
+	})
 
-		offset := (rect.Width() - i.Bounds().Max.X) / 2
-		draw.Draw(ximg, i.Bounds().Add(image.Pt(offset, 0)), i, image.ZP, draw.Over)
+	cbKey.Connect(X, win.Id)
+	cbMsg.Connect(X, win.Id)
+	cbCfg.Connect(X, win.Id)
+	cbPress.Connect(X, win.Id)
+	cbMotion.Connect(X, win.Id)
+	cbBut.Connect(X, win.Id)
+	cbExp.Connect(X, win.Id)
 
-		state |= scaled
+	win.Map()
+
+	err = v.Update()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
 	}
 
-	drawImage := func() {
-		title := fmt.Sprintf("%s [%d of %d] - %s (%dx%d)", appName, idx+1, len(images),
-			images[idx], img.Bounds().Max.X, img.Bounds().Max.Y)
+	xevent.Main(X)
 
-		err = ewmh.WmNameSet(ximg.X, win.Id, title)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "WmNameSet: %s\n", err.Error())
-		}
+	w.free()
+}
 
-		if useShm {
-			pid, err := xproto.NewPixmapId(ximg.X.Conn())
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "NewPixmapId: %s\n", err.Error())
-			}
+// x11Window draws on X11 window.
+type x11Window struct {
+	X    *xgbutil.XUtil
+	win  *xwindow.Window
+	rect xrect.Rect
 
-			mshm.CreatePixmap(X.Conn(), pid, xproto.Drawable(ximg.X.RootWin()),
-				uint16(ximg.Bounds().Dx()), uint16(ximg.Bounds().Dy()),
-				ximg.X.Screen().RootDepth, seg, 0)
+	useShm bool
+	shmId  int
+	seg    mshm.Seg
+	data   []byte
 
-			ximg.Pixmap = pid
+	ximg *xgraphics.Image
 
-			mshm.PutImage(X.Conn(), xproto.Drawable(ximg.Pixmap), ximg.X.GC(),
-				uint16(ximg.Bounds().Dx()), uint16(ximg.Bounds().Dy()),
-				0, 0, 0, 0, 0, 0, ximg.X.Screen().RootDepth,
-				xproto.ImageFormatZPixmap, 0, seg, 0)
+	wake  xproto.Atom
+	mu    sync.Mutex
+	queue []func()
+}
 
-			ximg.XExpPaint(win.Id, 0, 0)
-		} else {
-			err = ximg.CreatePixmap()
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "CreatePixmap: %s\n", err.Error())
-			}
+// Size returns window size.
+func (w *x11Window) Size() (int, int) {
+	return w.rect.Width(), w.rect.Height()
+}
 
-			ximg.XDraw()
-			ximg.XExpPaint(win.Id, 0, 0)
-		}
+// Fullscreen toggles fullscreen.
+func (w *x11Window) Fullscreen() {
+	err := ewmh.WmStateReq(w.X, w.win.Id, ewmh.StateToggle, "_NET_WM_STATE_FULLSCREEN")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "WmStateReq: %s\n", err.Error())
+	}
+}
+
+// Quit stops the event loop.
+func (w *x11Window) Quit() {
+	xevent.Quit(w.X)
+}
 
-		state |= drawn
+// Status sets window title.
+func (w *x11Window) Status(title string) {
+	err := ewmh.WmNameSet(w.X, w.win.Id, title)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "WmNameSet: %s\n", err.Error())
 	}
+}
 
-	update := func() {
-		if state&loaded == 0 {
-			loadImage()
-			if img == nil {
-				return
-			}
-		}
-		if state&scaled == 0 {
-			scaleImage()
-		}
-		if state&drawn == 0 {
-			drawImage()
-		}
+// Synchronize queues f and wakes up the event loop with client message.
+func (w *x11Window) Synchronize(f func()) {
+	w.mu.Lock()
+	w.queue = append(w.queue, f)
+	w.mu.Unlock()
+
+	ev := xproto.ClientMessageEvent{
+		Format: 32,
+		Window: w.win.Id,
+		Type:   w.wake,
+		Data:   xproto.ClientMessageDataUnionData32New(make([]uint32, 5)),
 	}
 
-	cbKey := xevent.KeyPressFun(func(xu *xgbutil.XUtil, e xevent.KeyPressEvent) {
-		if keybind.KeyMatch(X, "Escape", e.State, e.Detail) || keybind.KeyMatch(X, "q", e.State, e.Detail) {
-			xevent.Quit(X)
-		}
+	xproto.SendEvent(w.X.Conn(), false, w.win.Id, 0, string(ev.Bytes()))
+}
 
-		if keybind.KeyMatch(xu, "Left", e.State, e.Detail) || keybind.KeyMatch(xu, "Page_Up", e.State, e.Detail) || keybind.KeyMatch(xu, "k", e.State, e.Detail) {
-			if idx != 0 {
-				idx -= 1
-				state &= loaded
-				state &= drawn
-				update()
-			}
-		} else if keybind.KeyMatch(xu, "Right", e.State, e.Detail) || keybind.KeyMatch(xu, "Page_Down", e.State, e.Detail) ||
-			keybind.KeyMatch(xu, "j", e.State, e.Detail) || keybind.KeyMatch(xu, " ", e.State, e.Detail) {
-			if idx != len(images)-1 {
-				idx += 1
-				state &= loaded
-				state &= drawn
-				update()
-			}
-		}
+// run runs queued functions.
+func (w *x11Window) run() {
+	w.mu.Lock()
+	queue := w.queue
+	w.queue = nil
+	w.mu.Unlock()
 
-		if keybind.KeyMatch(X, "F11", e.State, e.Detail) || keybind.KeyMatch(X, "f", e.State, e.Detail) || keybind.KeyMatch(X, "L1", e.State, e.Detail) {
-			err = ewmh.WmStateReq(X, win.Id, ewmh.StateToggle, "_NET_WM_STATE_FULLSCREEN")
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "WmStateReq: %s\n", err.Error())
-			}
-		}
+	for _, f := range queue {
+		f()
+	}
+}
 
-		if keybind.KeyMatch(X, "[", e.State, e.Detail) {
-			if idx-10 >= 0 {
-				idx -= 10
-				state &= loaded
-				state &= drawn
-				update()
-			}
-		} else if keybind.KeyMatch(X, "]", e.State, e.Detail) {
-			if idx+10 <= len(images)-1 {
-				idx += 10
-				state &= loaded
-				state &= drawn
-				update()
+// Draw draws image in window and sets title.
+func (w *x11Window) Draw(img image.Image, title string) error {
+	if w.ximg == nil || !w.ximg.Bounds().Eq(img.Bounds()) {
+		w.free()
+		w.ximg = w.newImage(img.Bounds())
+	}
+
+	if i, ok := img.(*image.RGBA); ok {
+		for y := 0; y < i.Rect.Dy(); y++ {
+			src := i.Pix[y*i.Stride : y*i.Stride+i.Rect.Dx()*4]
+			dst := w.ximg.Pix[y*w.ximg.Stride:]
+			for x := 0; x < len(src); x += 4 {
+				dst[x], dst[x+1], dst[x+2], dst[x+3] = src[x+2], src[x+1], src[x], src[x+3]
 			}
 		}
+	} else {
+		draw.Draw(w.ximg, img.Bounds(), img, image.ZP, draw.Src)
+	}
 
-		if keybind.KeyMatch(X, ",", e.State, e.Detail) {
-			idx = 0
-			state &= loaded
-			state &= drawn
-			update()
-		} else if keybind.KeyMatch(X, ".", e.State, e.Detail) {
-			idx = len(images) - 1
-			state &= loaded
-			state &= drawn
-			update()
-		}
+	w.Status(title)
 
-		if keybind.KeyMatch(X, "Return", e.State, e.Detail) {
-			fmt.Fprintf(os.Stdout, "%s\n", images[idx])
-		}
-	})
+	w.ximg.Destroy()
 
-	cbBut := mousebind.ButtonReleaseFun(func(xu *xgbutil.XUtil, e xevent.ButtonReleaseEvent) {
-		if e.Detail == 1 {
-			if idx != len(images)-1 {
-				idx += 1
-				state &= loaded
-				state &= drawn
-				update()
-			}
-		} else if e.Detail == 3 {
-			if idx != 0 {
-				idx -= 1
-				state &= loaded
-				state &= drawn
-				update()
-			}
+	if w.useShm {
+		pid, err := xproto.NewPixmapId(w.X.Conn())
+		if err != nil {
+			return fmt.Errorf("NewPixmapId: %s", err.Error())
 		}
-	})
 
-	cbCfg := xevent.ConfigureNotifyFun(func(xu *xgbutil.XUtil, e xevent.ConfigureNotifyEvent) {
-		if rect.Width() != int(e.Width) || rect.Height() != int(e.Height) {
-			state &= drawn
-			state &= scaled
+		mshm.CreatePixmap(w.X.Conn(), pid, xproto.Drawable(w.X.RootWin()),
+			uint16(w.ximg.Bounds().Dx()), uint16(w.ximg.Bounds().Dy()),
+			w.X.Screen().RootDepth, w.seg, 0)
 
-			rect, err = win.Geometry()
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Geometry: %s\n", err.Error())
-			}
-		}
-	})
+		w.ximg.Pixmap = pid
 
-	cbExp := xevent.ExposeFun(func(xu *xgbutil.XUtil, e xevent.ExposeEvent) {
-		if e.ExposeEvent.Count == 0 {
-			state &= drawn
-			state &= scaled
-			update()
+		mshm.PutImage(w.X.Conn(), xproto.Drawable(w.ximg.Pixmap), w.X.GC(),
+			uint16(w.ximg.Bounds().Dx()), uint16(w.ximg.Bounds().Dy()),
+			0, 0, 0, 0, 0, 0, w.X.Screen().RootDepth,
+			xproto.ImageFormatZPixmap, 0, w.seg, 0)
+	} else {
+		err := w.ximg.CreatePixmap()
+		if err != nil {
+			return fmt.Errorf("CreatePixmap: %s", err.Error())
 		}
-	})
 
-	cbKey.Connect(X, win.Id)
-	cbCfg.Connect(X, win.Id)
-	cbBut.Connect(X, win.Id, "1", false, true)
-	cbBut.Connect(X, win.Id, "3", false, true)
-	cbExp.Connect(X, win.Id)
+		w.ximg.XDraw()
+	}
 
-	win.Map()
-	xevent.Main(X)
+	w.ximg.XExpPaint(w.win.Id, 0, 0)
+
+	return nil
+}
+
+// newImage returns new X image, backed by shared memory if available.
+func (w *x11Window) newImage(r image.Rectangle) *xgraphics.Image {
+	if !w.useShm {
+		return xgraphics.New(w.X, r)
+	}
 
-	if useShm {
-		mshm.Detach(X.Conn(), seg)
-		shm.Rm(shmId)
-		shm.Dt(data)
+	var err error
+
+	w.shmId, err = shm.Get(shm.IPC_PRIVATE, r.Dx()*r.Dy()*4, shm.IPC_CREAT|0777)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Get: %s\n", err.Error())
+	}
+
+	w.seg, err = mshm.NewSegId(w.X.Conn())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "NewSegId: %s\n", err.Error())
+	}
+
+	w.data, err = shm.At(w.shmId, 0, 0)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "At: %s\n", err.Error())
 	}
+
+	mshm.Attach(w.X.Conn(), w.seg, uint32(w.shmId), false)
+
+	return &xgraphics.Image{
+		X:      w.X,
+		Pixmap: 0,
+		Pix:    w.data,
+		Stride: 4 * r.Dx(),
+		Rect:   r,
+		Subimg: false,
+	}
+}
+
+// free releases X image and shared memory.
+func (w *x11Window) free() {
+	if w.ximg == nil {
+		return
+	}
+
+	w.ximg.Destroy()
+	w.ximg = nil
+
+	if w.useShm {
+		mshm.Detach(w.X.Conn(), w.seg)
+		shm.Rm(w.shmId)
+		shm.Dt(w.data)
+	}
+}
+
+// x11Key returns key name of keysym name with modifiers in state.
+// Keysyms with more than one name get the same name, xgbutil returns any of them, i.e. Next or Page_Down.
+func x11Key(name string, state uint16) string {
+	name = keyName(name)
+	if name == " " {
+		name = "space"
+	}
+
+	if state&xproto.ModMask1 != 0 {
+		name = "alt+" + name
+	}
+	if state&xproto.ModMaskControl != 0 {
+		name = "ctrl+" + name
+	}
+
+	return name
 }
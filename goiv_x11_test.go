@@ -0,0 +1,51 @@
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package main
+
+import (
+	"testing"
+
+	"github.com/BurntSushi/xgb/xproto"
+	"github.com/BurntSushi/xgbutil/keybind"
+)
+
+func TestX11Key(t *testing.T) {
+	tests := []struct {
+		name  string
+		state uint16
+		want  string
+	}{
+		{"Page_Down", 0, "Page_Down"},
+		{"Next", 0, "Page_Down"},
+		{"Prior", 0, "Page_Up"},
+		{"Next", xproto.ModMaskControl, "ctrl+Page_Down"},
+		{"Prior", xproto.ModMaskControl, "ctrl+Page_Up"},
+		{"L1", 0, "F11"},
+		{"F11", 0, "F11"},
+		{"bracketleft", 0, "["},
+		{" ", 0, "space"},
+		{"v", xproto.ModMask1, "alt+v"},
+	}
+
+	for _, tt := range tests {
+		if got := x11Key(tt.name, tt.state); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	// name returned by xgbutil for aliased keysyms is not stable between runs
+	keysyms := map[xproto.Keysym]string{
+		0xff55: "Page_Up",
+		0xff56: "Page_Down",
+		0xff9a: "KP_Page_Up",
+		0xff9b: "KP_Page_Down",
+		0xffc8: "F11",
+		0xffc9: "F12",
+	}
+
+	for sym, want := range keysyms {
+		if got := x11Key(keybind.KeysymToStr(sym), 0); got != want {
+			t.Errorf("keysym %#x: got %s, want %s", sym, got, want)
+		}
+	}
+}
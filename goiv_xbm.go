@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+)
+
+func init() {
+	image.RegisterFormat("xbm", "#define", decodeXBM, decodeXBMConfig)
+}
+
+var (
+	xbmDefine = regexp.MustCompile(`#define\s+\S*_(width|height)\s+(\d+)`)
+	xbmByte   = regexp.MustCompile(`0[xX][0-9a-fA-F]{1,2}\b`)
+)
+
+// xbmSize returns size of XBM image and its data after the size defines.
+func xbmSize(data []byte) (int, int, []byte, error) {
+	width, height, end := 0, 0, 0
+
+	for _, m := range xbmDefine.FindAllSubmatchIndex(data, -1) {
+		n, err := strconv.Atoi(string(data[m[4]:m[5]]))
+		if err != nil {
+			return 0, 0, nil, fmt.Errorf("xbm: %s", err.Error())
+		}
+
+		if string(data[m[2]:m[3]]) == "width" {
+			width = n
+		} else {
+			height = n
+		}
+
+		end = m[1]
+	}
+
+	if width <= 0 || height <= 0 || width > 1<<16 || height > 1<<16 {
+		return 0, 0, nil, fmt.Errorf("xbm: invalid size %dx%d", width, height)
+	}
+
+	return width, height, data[end:], nil
+}
+
+// decodeXBMConfig returns color model and size of XBM image.
+func decodeXBMConfig(r io.Reader) (image.Config, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	width, height, _, err := xbmSize(data)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	return image.Config{ColorModel: color.GrayModel, Width: width, Height: height}, nil
+}
+
+// decodeXBM decodes XBM image, set bits are black.
+func decodeXBM(r io.Reader) (image.Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	width, height, data, err := xbmSize(data)
+	if err != nil {
+		return nil, err
+	}
+
+	stride := (width + 7) / 8
+
+	hex := xbmByte.FindAll(data, stride*height)
+	if len(hex) < stride*height {
+		return nil, fmt.Errorf("xbm: unexpected end of data")
+	}
+
+	bits := make([]byte, len(hex))
+	for i, h := range hex {
+		b, _ := strconv.ParseUint(string(h[2:]), 16, 8)
+		bits[i] = byte(b)
+	}
+
+	img := image.NewGray(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			// least significant bit is the leftmost pixel
+			if bits[y*stride+x/8]&(1<<uint(x%8)) == 0 {
+				img.Pix[y*img.Stride+x] = 255
+			}
+		}
+	}
+
+	return img, nil
+}
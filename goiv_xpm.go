@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	image.RegisterFormat("xpm", "/* XPM */", decodeXPM, decodeXPMConfig)
+}
+
+var xpmString = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"`)
+
+// xpmColors are common X11 color names, other names are black.
+var xpmColors = map[string]color.NRGBA{
+	"black":   {0, 0, 0, 255},
+	"white":   {255, 255, 255, 255},
+	"red":     {255, 0, 0, 255},
+	"green":   {0, 255, 0, 255},
+	"blue":    {0, 0, 255, 255},
+	"yellow":  {255, 255, 0, 255},
+	"cyan":    {0, 255, 255, 255},
+	"magenta": {255, 0, 255, 255},
+	"gray":    {190, 190, 190, 255},
+	"grey":    {190, 190, 190, 255},
+	"orange":  {255, 165, 0, 255},
+	"brown":   {165, 42, 42, 255},
+	"purple":  {160, 32, 240, 255},
+	"pink":    {255, 192, 203, 255},
+	"none":    {0, 0, 0, 0},
+}
+
+// xpmHeader is header of XPM image with its strings.
+type xpmHeader struct {
+	width   int
+	height  int
+	ncolors int
+	cpp     int
+	lines   []string
+}
+
+// readXPMHeader parses strings of XPM image and its header values.
+func readXPMHeader(data []byte) (*xpmHeader, error) {
+	h := &xpmHeader{}
+
+	for _, m := range xpmString.FindAllSubmatch(data, -1) {
+		h.lines = append(h.lines, string(m[1]))
+	}
+
+	if len(h.lines) == 0 {
+		return nil, fmt.Errorf("xpm: missing header")
+	}
+
+	values := strings.Fields(h.lines[0])
+	if len(values) < 4 {
+		return nil, fmt.Errorf("xpm: invalid header %s", h.lines[0])
+	}
+
+	for i, v := range []*int{&h.width, &h.height, &h.ncolors, &h.cpp} {
+		n, err := strconv.Atoi(values[i])
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("xpm: invalid header %s", h.lines[0])
+		}
+		*v = n
+	}
+
+	if !validSize(h.width, h.height, 4) || h.cpp > 8 {
+		return nil, fmt.Errorf("xpm: invalid header %s", h.lines[0])
+	}
+
+	return h, nil
+}
+
+// decodeXPMConfig returns color model and size of XPM image.
+func decodeXPMConfig(r io.Reader) (image.Config, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	h, err := readXPMHeader(data)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	return image.Config{ColorModel: color.NRGBAModel, Width: h.width, Height: h.height}, nil
+}
+
+// decodeXPM decodes XPM image, color of c key is used, or of g and m keys when missing.
+func decodeXPM(r io.Reader) (image.Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := readXPMHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.ncolors >= len(h.lines) || len(h.lines)-1-h.ncolors < h.height {
+		return nil, fmt.Errorf("xpm: unexpected end of data")
+	}
+
+	rows := h.lines[1+h.ncolors : 1+h.ncolors+h.height]
+	for y, line := range rows {
+		if len(line) < h.width*h.cpp {
+			return nil, fmt.Errorf("xpm: invalid row %d", y+1)
+		}
+	}
+
+	colors := make(map[string]color.NRGBA)
+	for _, line := range h.lines[1 : 1+h.ncolors] {
+		if len(line) < h.cpp {
+			return nil, fmt.Errorf("xpm: invalid color %s", line)
+		}
+
+		colors[line[:h.cpp]] = xpmColor(strings.Fields(line[h.cpp:]))
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, h.width, h.height))
+
+	for y, line := range rows {
+		for x := 0; x < h.width; x++ {
+			img.SetNRGBA(x, y, colors[line[x*h.cpp:(x+1)*h.cpp]])
+		}
+	}
+
+	return img, nil
+}
+
+// xpmColor returns color from keys and values of XPM color definition.
+func xpmColor(fields []string) color.NRGBA {
+	values := make(map[string]string)
+
+	// color names can have spaces, i.e. c light blue
+	key := ""
+	for _, f := range fields {
+		switch f {
+		case "c", "g", "g4", "m", "s":
+			key = f
+			values[key] = ""
+		default:
+			if key != "" {
+				values[key] = strings.TrimSpace(values[key] + " " + f)
+			}
+		}
+	}
+
+	for _, key := range []string{"c", "g", "g4", "m"} {
+		if v, ok := values[key]; ok && v != "" {
+			return parseXPMColor(v)
+		}
+	}
+
+	return color.NRGBA{0, 0, 0, 255}
+}
+
+// parseXPMColor parses #rgb color with 4, 8 or 16 bits per channel, grayN or color name.
+func parseXPMColor(s string) color.NRGBA {
+	s = strings.ToLower(s)
+
+	if strings.HasPrefix(s, "#") {
+		hex := s[1:]
+		if len(hex)%3 != 0 || len(hex) == 0 || len(hex) > 12 {
+			return color.NRGBA{0, 0, 0, 255}
+		}
+
+		n := len(hex) / 3
+		var c [3]uint8
+		for i := range c {
+			v, err := strconv.ParseUint(hex[i*n:(i+1)*n], 16, 16)
+			if err != nil {
+				return color.NRGBA{0, 0, 0, 255}
+			}
+
+			// scale to 8 bits from n hex digits
+			c[i] = uint8(v * 255 / (1<<uint(4*n) - 1))
+		}
+
+		return color.NRGBA{c[0], c[1], c[2], 255}
+	}
+
+	name := strings.Replace(s, " ", "", -1)
+
+	if c, ok := xpmColors[name]; ok {
+		return c
+	}
+
+	for _, prefix := range []string{"gray", "grey"} {
+		if strings.HasPrefix(name, prefix) {
+			n, err := strconv.Atoi(name[len(prefix):])
+			if err == nil && n >= 0 && n <= 100 {
+				v := uint8((n*255 + 50) / 100)
+				return color.NRGBA{v, v, v, 255}
+			}
+		}
+	}
+
+	return color.NRGBA{0, 0, 0, 255}
+}